@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// startTime is when the server process started, for the uptime in the stats
+var startTime = time.Now()
+
+type wikiStatsJSON struct {
+	Wiki               string `json:"wiki"`
+	Content            string `json:"content"`
+	Titles             int    `json:"titles"`
+	CachedArticles     int    `json:"cachedArticles"`
+	ArticleCacheSize   int    `json:"articleCacheSize"`
+	ChunkCacheBytes    int64  `json:"chunkCacheBytes"`
+	ChunkCacheMaxBytes int64  `json:"chunkCacheMaxBytes"`
+}
+
+type memoryStatsJSON struct {
+	Alloc       uint64 `json:"alloc"`
+	Sys         uint64 `json:"sys"`
+	HeapObjects uint64 `json:"heapObjects"`
+	NumGC       uint32 `json:"numGC"`
+}
+
+type statsJSON struct {
+	UptimeSeconds float64         `json:"uptimeSeconds"`
+	Wikis         []wikiStatsJSON `json:"wikis"`
+	Requests      uint64          `json:"requests"`
+	CacheHits     uint64          `json:"cacheHits"`
+	CacheMisses   uint64          `json:"cacheMisses"`
+	CacheHitRate  float64         `json:"cacheHitRate"`
+	Memory        memoryStatsJSON `json:"memory"`
+}
+
+func (h *TinyWikiHandler) stats(wiki string) wikiStatsJSON {
+	data := h.data.Load()
+	return wikiStatsJSON{
+		Wiki:               wiki,
+		Content:            h.contentFilePath,
+		Titles:             data.index.Len(),
+		CachedArticles:     data.cache.len(),
+		ArticleCacheSize:   data.cache.capacity,
+		ChunkCacheBytes:    data.chunks.bytes(),
+		ChunkCacheMaxBytes: data.chunks.maxBytes,
+	}
+}
+
+// statsHandler serves the state of the server and all of its wikis as JSON
+// for operators. The default wiki is listed as "default" unless it is one of
+// the -wiki ones.
+func statsHandler(wikiHandler *TinyWikiHandler, langHandlers map[string]*TinyWikiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var stats statsJSON
+		stats.UptimeSeconds = time.Since(startTime).Seconds()
+		if len(langHandlers) == 0 {
+			stats.Wikis = append(stats.Wikis, wikiHandler.stats("default"))
+		}
+		for lang, langHandler := range langHandlers {
+			stats.Wikis = append(stats.Wikis, langHandler.stats(lang))
+		}
+		sort.Slice(stats.Wikis, func(i, j int) bool { return stats.Wikis[i].Wiki < stats.Wikis[j].Wiki })
+		stats.Requests = metrics.requests.Load()
+		stats.CacheHits, stats.CacheMisses = metrics.cacheHits.Load(), metrics.cacheMisses.Load()
+		if lookups := stats.CacheHits + stats.CacheMisses; lookups > 0 {
+			stats.CacheHitRate = float64(stats.CacheHits) / float64(lookups)
+		}
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		stats.Memory = memoryStatsJSON{mem.Alloc, mem.Sys, mem.HeapObjects, mem.NumGC}
+		writeJSON(w, http.StatusOK, stats)
+	}
+}
+
+// adminHandler only lets requests through to next that carry token as a
+// bearer token in the Authorization header. Without a token all requests are
+// let through.
+func adminHandler(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, errorJSON{"unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestAdminEndpoints(t *testing.T) {
+	h := newTestHandler(t)
+	stats := statsHandler(h, nil)
+	tests := []struct {
+		name, token, authorization string
+		pprof                      bool
+		target                     string
+		status                     int
+	}{
+		{"stats without token", "", "", false, "/admin/stats", http.StatusOK},
+		{"stats without token ignoring a sent one", "", "Bearer secret", false, "/admin/stats", http.StatusOK},
+		{"stats with a token set but not sent", "secret", "", false, "/admin/stats", http.StatusUnauthorized},
+		{"stats with a wrong token", "secret", "Bearer wrong", false, "/admin/stats", http.StatusUnauthorized},
+		{"stats with the token", "secret", "Bearer secret", false, "/admin/stats", http.StatusOK},
+		{"pprof without token", "", "", true, "/debug/pprof/", http.StatusOK},
+		{"pprof disabled without token", "", "", false, "/debug/pprof/", http.StatusNotFound},
+		{"pprof disabled", "secret", "Bearer secret", false, "/debug/pprof/", http.StatusNotFound},
+		{"pprof without the token sent", "secret", "", true, "/debug/pprof/", http.StatusUnauthorized},
+		{"pprof with the token", "secret", "Bearer secret", true, "/debug/pprof/", http.StatusOK},
+		{"pprof profile with the token", "secret", "Bearer secret", true, "/debug/pprof/goroutine?debug=1", http.StatusOK},
+		{"other routes", "secret", "", true, "/healthz", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.Handle("/admin/stats", adminHandler(tt.token, stats))
+			mux.Handle(pprofPrefix, http.DefaultServeMux)
+			mux.HandleFunc("/healthz", h.ServeHealth)
+			var header []string
+			if tt.authorization != "" {
+				header = []string{"Authorization", tt.authorization}
+			}
+			rec := get(pprofHandler(tt.pprof, tt.token, mux), tt.target, header...)
+			if rec.Code != tt.status {
+				t.Errorf("got %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	h := newTestHandler(t)
+	de := loadTestWiki(t, "testdata/dewiki-index.txt.bz2", "testdata/dewiki.xml.bz2", "/wiki/de/")
+	rec := get(statsHandler(h, map[string]*TinyWikiHandler{"en": h, "de": de}), "/admin/stats")
+	var stats statsJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Wikis) != 2 || stats.Wikis[0].Wiki != "de" || stats.Wikis[1].Wiki != "en" {
+		t.Fatalf("got wikis %+v, want de and en", stats.Wikis)
+	}
+	if stats.Wikis[0].Titles != 2 || stats.Wikis[1].Titles != h.index().Len() {
+		t.Errorf("got %d and %d titles, want 2 and %d", stats.Wikis[0].Titles, stats.Wikis[1].Titles, h.index().Len())
+	}
+}
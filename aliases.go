@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readAliases reads a file of alias<TAB>title lines mapping custom names to
+// the titles of articles. Empty lines and lines starting with # are skipped.
+// Both sides are normalized like the titles of requests.
+func readAliases(aliasesPath string) (map[string]string, error) {
+	aliasesFile, err := os.Open(aliasesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer aliasesFile.Close()
+	aliases := make(map[string]string)
+	scanner := bufio.NewScanner(aliasesFile)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		alias, title, ok := strings.Cut(line, "\t")
+		alias, title = strings.TrimSpace(alias), strings.TrimSpace(title)
+		if !ok || alias == "" || title == "" {
+			return nil, fmt.Errorf("%s:%d: expected alias<TAB>title", aliasesPath, lineNo)
+		}
+		aliases[normalizeTitle(alias)] = normalizeTitle(title)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return aliases, nil
+}
+
+// loadAliases replaces the aliases of the handler by those in aliasesPath
+func (h *TinyWikiHandler) loadAliases(aliasesPath string) error {
+	aliases, err := readAliases(aliasesPath)
+	if err != nil {
+		return err
+	}
+	h.aliases.Store(&aliases)
+	logInfo("Loaded", len(aliases), "aliases from", aliasesPath)
+	return nil
+}
+
+// alias returns the title the alias rawTitle stands for
+func (h *TinyWikiHandler) alias(rawTitle string) (string, bool) {
+	aliases := h.aliases.Load()
+	if aliases == nil {
+		return "", false
+	}
+	title, ok := (*aliases)[normalizeTitle(rawTitle)]
+	return title, ok
+}
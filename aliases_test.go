@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAliases(t *testing.T) {
+	aliasesPath := filepath.Join(t.TempDir(), "aliases.txt")
+	aliases := "# Short names\nTuring\tAlan_Turing\n\nthe big apple\tNew York City\n"
+	if err := os.WriteFile(aliasesPath, []byte(aliases), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(t)
+	if err := h.loadAliases(aliasesPath); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target   string
+		status   int
+		location string
+	}{
+		{"/wiki/Turing", 302, "/wiki/Alan_Turing"},
+		{"/wiki/The_big_apple", 302, "/wiki/New_York_City"},
+		{"/wiki/Alan_Turing", 200, ""},
+	}
+	for _, tt := range tests {
+		rec := get(wikiRoute(h), tt.target)
+		if rec.Code != tt.status || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s: got %d to %q, want %d to %q", tt.target, rec.Code, rec.Header().Get("Location"), tt.status, tt.location)
+		}
+	}
+
+	if err := os.WriteFile(aliasesPath, []byte("Turing without tab\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.loadAliases(aliasesPath); err == nil {
+		t.Error("loaded a malformed aliases file")
+	}
+	if rec := get(wikiRoute(h), "/wiki/Turing"); rec.Code != 302 {
+		t.Errorf("after a failed reload: got %d, want the old aliases kept", rec.Code)
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultCompleteLimit = 20
+	maxCompleteLimit     = 100
+	defaultSearchLimit   = 20
+	maxSearchLimit       = 100
+	defaultTitlesLimit   = 1000
+	maxTitlesLimit       = 10000
+)
+
+type articleJSON struct {
+	Title     string `json:"title"`
+	Id        uint64 `json:"id"`
+	Offset    int64  `json:"offset"`
+	Content   string `json:"content"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+type metaJSON struct {
+	Title          string   `json:"title"`
+	DisplayTitle   string   `json:"displayTitle"`
+	Id             uint64   `json:"id"`
+	RevisionId     uint64   `json:"revisionId"`
+	Timestamp      string   `json:"timestamp"`
+	Contributor    string   `json:"contributor"`
+	ContributorId  uint64   `json:"contributorId,omitempty"`
+	Disambiguation bool     `json:"disambiguation"`
+	Categories     []string `json:"categories"`
+	Words          int      `json:"words"`
+	Characters     int      `json:"characters"`
+	ReadingMinutes int      `json:"readingMinutes"`
+}
+
+// existsJSON tells whether a title is in the index. Id and Offset are left
+// out for missing titles.
+type existsJSON struct {
+	Exists bool   `json:"exists"`
+	Id     uint64 `json:"id,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+}
+
+type summaryJSON struct {
+	Title    string `json:"title"`
+	Extract  string `json:"extract"`
+	Wikitext string `json:"wikitext"`
+}
+
+type infoboxJSON struct {
+	Title  string            `json:"title"`
+	Name   string            `json:"name"`
+	Fields map[string]string `json:"fields"`
+}
+
+type linksJSON struct {
+	Title string   `json:"title"`
+	Links []string `json:"links"`
+}
+
+// titlesJSON is a page of the sorted title list. Next is the offset of the
+// following page and left out on the last page.
+type titlesJSON struct {
+	Titles []string `json:"titles"`
+	Total  int      `json:"total"`
+	Next   int      `json:"next,omitempty"`
+}
+
+type searchResultJSON struct {
+	Title string  `json:"title"`
+	Score float64 `json:"score"`
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+type notFoundJSON struct {
+	Error       string   `json:"error"`
+	Suggestions []string `json:"suggestions"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logError(err)
+	}
+}
+
+// writeJSONBody writes v as a 200 response through writeBody, so it gets an
+// ETag and conditional requests are answered with 304 Not Modified
+func writeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		logError(err)
+		writeJSON(w, http.StatusInternalServerError, errorJSON{"failed to encode response"})
+		return
+	}
+	writeBody(w, r, "application/json", string(body)+"\n")
+}
+
+// writeJSONError writes message as the body of an error response of the JSON
+// API, it goes with checkTitle like http.Error
+func writeJSONError(w http.ResponseWriter, message string, status int) {
+	writeJSON(w, status, errorJSON{message})
+}
+
+// checkTitle answers a request whose path names no title or one no article
+// can have, as told by validateTitle, with 400 Bad Request written by fail
+// and reports whether the request may go on. Every route serving the title
+// named by its path checks it this way before any lookup.
+func checkTitle(w http.ResponseWriter, r *http.Request, fail func(w http.ResponseWriter, message string, status int)) bool {
+	if strings.TrimSpace(r.URL.Path) == "" {
+		fail(w, "missing title", http.StatusBadRequest)
+		return false
+	}
+	if err := validateTitle(normalizeTitle(r.URL.Path)); err != nil {
+		fail(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// notModified sends the version of data as ETag for responses computed from
+// the index alone, like title lists, and answers a request already having it
+// with 304 Not Modified. Caches have to revalidate as a reload changes the
+// ETag.
+func notModified(w http.ResponseWriter, r *http.Request, data *wikiData) bool {
+	etag := `W/"` + data.version + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, no-cache")
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// ServeArticleJSON serves the article named by the request path together
+// with its index information as a JSON object.
+func (h *TinyWikiHandler) ServeArticleJSON(w http.ResponseWriter, r *http.Request) {
+	if !checkTitle(w, r, writeJSONError) {
+		return
+	}
+	title, offsetAndId, content, err := h.lookup(r.Context(), r.URL.Path)
+	noteTitle(r, title)
+	if err == errArticleNotFound {
+		writeJSON(w, http.StatusNotFound, notFoundJSON{"not found", suggestTitles(h.index(), title)})
+		return
+	}
+	if err != nil {
+		logError(err)
+		status, message := extractionErrorStatus(err)
+		writeJSON(w, status, errorJSON{message})
+		return
+	}
+	if r.URL.Query().Get("skipDab") == "1" && isDisambiguation(content) {
+		writeJSON(w, http.StatusNotFound, errorJSON{"disambiguation page"})
+		return
+	}
+	content, truncated := h.truncate(w, content)
+	writeJSONBody(w, r, articleJSON{title, offsetAndId.Id, offsetAndId.Offset, content, truncated})
+}
+
+// ServeComplete serves a JSON list of titles starting with the prefix given
+// in the q parameter.
+func (h *TinyWikiHandler) ServeComplete(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultCompleteLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			writeJSON(w, http.StatusBadRequest, errorJSON{"invalid limit"})
+			return
+		}
+		if limit > maxCompleteLimit {
+			limit = maxCompleteLimit
+		}
+	}
+	data := h.data.Load()
+	if notModified(w, r, data) {
+		return
+	}
+	writeJSON(w, http.StatusOK, completePrefix(data.index, query.Get("q"), limit))
+}
+
+// ServeSearchJSON serves the titles of the articles containing all words of
+// the q parameter ranked by relevance. It is only available once a search
+// index has been loaded or built.
+func (h *TinyWikiHandler) ServeSearchJSON(w http.ResponseWriter, r *http.Request) {
+	search := h.search.Load()
+	if search == nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorJSON{"search index not available"})
+		return
+	}
+	query := r.URL.Query()
+	limit := defaultSearchLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			writeJSON(w, http.StatusBadRequest, errorJSON{"invalid limit"})
+			return
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+	}
+	results := []searchResultJSON{}
+	for _, result := range search.query(query.Get("q"), limit, h.index()) {
+		results = append(results, searchResultJSON{result.Title, result.Score})
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// ServeTitleSearchJSON serves a JSON list of the titles containing the q
+// parameter anywhere regardless of case. Titles starting with it are listed
+// first.
+func (h *TinyWikiHandler) ServeTitleSearchJSON(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.Replace(query.Get("q"), "_", " ", -1)
+	if strings.TrimSpace(q) == "" {
+		writeJSON(w, http.StatusBadRequest, errorJSON{"missing q"})
+		return
+	}
+	limit := defaultSearchLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			writeJSON(w, http.StatusBadRequest, errorJSON{"invalid limit"})
+			return
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+	}
+	data := h.data.Load()
+	if notModified(w, r, data) {
+		return
+	}
+	writeJSON(w, http.StatusOK, containingTitles(data.index, data.lowercaseTitles(), q, limit))
+}
+
+// ServeTitles serves the sorted list of all titles in pages selected by the
+// offset and limit parameters.
+func (h *TinyWikiHandler) ServeTitles(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	offset := 0
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			writeJSON(w, http.StatusBadRequest, errorJSON{"invalid offset"})
+			return
+		}
+	}
+	limit := defaultTitlesLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			writeJSON(w, http.StatusBadRequest, errorJSON{"invalid limit"})
+			return
+		}
+		if limit > maxTitlesLimit {
+			limit = maxTitlesLimit
+		}
+	}
+	data := h.data.Load()
+	if notModified(w, r, data) {
+		return
+	}
+	index := data.index
+	total := index.Len()
+	offset = min(offset, total)
+	end := min(offset+limit, total)
+	page := titlesJSON{Titles: []string{}, Total: total}
+	for i := offset; i < end; i++ {
+		page.Titles = append(page.Titles, index.Title(i))
+	}
+	if end < total {
+		page.Next = end
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// ServeMetaJSON serves the revision metadata of the article named by the
+// request path.
+func (h *TinyWikiHandler) ServeMetaJSON(w http.ResponseWriter, r *http.Request) {
+	if !checkTitle(w, r, writeJSONError) {
+		return
+	}
+	title, offsetAndId, page, err := h.lookupPage(r.Context(), r.URL.Path)
+	noteTitle(r, title)
+	if err == errArticleNotFound {
+		writeJSON(w, http.StatusNotFound, errorJSON{"not found"})
+		return
+	}
+	if err != nil {
+		logError(err)
+		status, message := extractionErrorStatus(err)
+		writeJSON(w, status, errorJSON{message})
+		return
+	}
+	words, characters, minutes := textLength(page.Text)
+	display, _ := displayTitle(title, page.Text, h.linkBase)
+	writeJSON(w, http.StatusOK, metaJSON{
+		Title:          title,
+		DisplayTitle:   display,
+		Id:             offsetAndId.Id,
+		RevisionId:     page.RevisionId,
+		Timestamp:      page.Timestamp,
+		Contributor:    page.Contributor,
+		ContributorId:  page.ContributorId,
+		Disambiguation: isDisambiguation(page.Text),
+		Categories:     categoryNames(page.Text),
+		Words:          words,
+		Characters:     characters,
+		ReadingMinutes: minutes,
+	})
+}
+
+// ServeExistsJSON reports whether the title named by the request path is in
+// the index. Only the index is consulted, the dump is never read.
+func (h *TinyWikiHandler) ServeExistsJSON(w http.ResponseWriter, r *http.Request) {
+	if !checkTitle(w, r, writeJSONError) {
+		return
+	}
+	title, offsetAndId, ok := h.data.Load().findTitle(r.URL.Path)
+	noteTitle(r, title)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, existsJSON{})
+		return
+	}
+	writeJSON(w, http.StatusOK, existsJSON{true, offsetAndId.Id, offsetAndId.Offset})
+}
+
+// ServeSummaryJSON serves the lead section of the article named by the
+// request path, both as plain text and as wikitext.
+func (h *TinyWikiHandler) ServeSummaryJSON(w http.ResponseWriter, r *http.Request) {
+	if !checkTitle(w, r, writeJSONError) {
+		return
+	}
+	title, _, content, err := h.lookup(r.Context(), r.URL.Path)
+	noteTitle(r, title)
+	if err == errArticleNotFound {
+		writeJSON(w, http.StatusNotFound, notFoundJSON{"not found", suggestTitles(h.index(), title)})
+		return
+	}
+	if err != nil {
+		logError(err)
+		status, message := extractionErrorStatus(err)
+		writeJSON(w, status, errorJSON{message})
+		return
+	}
+	lead := leadSection(content)
+	writeJSON(w, http.StatusOK, summaryJSON{title, strings.TrimSpace(normalizeWhitespace(stripWikitext(lead))), lead})
+}
+
+// ServeLinksJSON serves the titles the article named by the request path
+// links to, without links to files and categories.
+func (h *TinyWikiHandler) ServeLinksJSON(w http.ResponseWriter, r *http.Request) {
+	if !checkTitle(w, r, writeJSONError) {
+		return
+	}
+	title, _, content, err := h.lookup(r.Context(), r.URL.Path)
+	noteTitle(r, title)
+	if err == errArticleNotFound {
+		writeJSON(w, http.StatusNotFound, notFoundJSON{"not found", suggestTitles(h.index(), title)})
+		return
+	}
+	if err != nil {
+		logError(err)
+		status, message := extractionErrorStatus(err)
+		writeJSON(w, status, errorJSON{message})
+		return
+	}
+	links := linkTargets(content)
+	if links == nil {
+		links = []string{}
+	}
+	writeJSON(w, http.StatusOK, linksJSON{title, links})
+}
+
+// ServeInfoboxJSON serves the parameters of the first infobox of the article
+// named by the request path.
+func (h *TinyWikiHandler) ServeInfoboxJSON(w http.ResponseWriter, r *http.Request) {
+	if !checkTitle(w, r, writeJSONError) {
+		return
+	}
+	title, _, content, err := h.lookup(r.Context(), r.URL.Path)
+	noteTitle(r, title)
+	if err == errArticleNotFound {
+		writeJSON(w, http.StatusNotFound, notFoundJSON{"not found", suggestTitles(h.index(), title)})
+		return
+	}
+	if err != nil {
+		logError(err)
+		status, message := extractionErrorStatus(err)
+		writeJSON(w, status, errorJSON{message})
+		return
+	}
+	name, fields, ok := parseInfobox(content)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorJSON{"no infobox"})
+		return
+	}
+	writeJSON(w, http.StatusOK, infoboxJSON{title, name, fields})
+}
+
+// ServeById redirects to the article whose page id is given by the request
+// path.
+func (h *TinyWikiHandler) ServeById(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.URL.Path, 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorJSON{"invalid id"})
+		return
+	}
+	title, ok := h.data.Load().titleById(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorJSON{"not found"})
+		return
+	}
+	http.Redirect(w, r, wikiURL(h.linkBase, title), http.StatusFound)
+}
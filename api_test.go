@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTitleRoutesRejectInvalidTitles(t *testing.T) {
+	h := newTestHandler(t)
+	h.backlinks.Store(&backlinkIndex{})
+	mux := http.NewServeMux()
+	mux.Handle("/wiki/", wikiRoute(h))
+	wikiRoutes(mux, "", h)
+	routes := []string{"/wiki/", "/text/", "/api/article/", "/api/meta/", "/api/exists/", "/api/links/",
+		"/api/summary/", "/api/infobox/", "/api/backlinks/"}
+	titles := []string{"A%3Cb%3E", "A%7Cb", "A%00b", strings.Repeat("x", maxTitleBytes+1)}
+	for _, route := range routes {
+		for _, title := range titles {
+			if rec := get(mux, route+title); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s%.20s: got %d, want %d", route, title, rec.Code, http.StatusBadRequest)
+			}
+		}
+		if rec := get(mux, route+"Alan_Turing"); rec.Code != http.StatusOK {
+			t.Errorf("%sAlan_Turing: got %d, want %d", route, rec.Code, http.StatusOK)
+		}
+	}
+	if rec := get(mux, "/wiki/A%3Cb%3E?action=raw&stream=1"); rec.Code != http.StatusBadRequest {
+		t.Errorf("streamed: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestTitleListETag(t *testing.T) {
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	wikiRoutes(mux, "", h)
+	for _, target := range []string{"/api/titles", "/api/complete?prefix=Sample", "/api/search/title?q=sample"} {
+		rec := get(mux, target)
+		etag := rec.Header().Get("ETag")
+		if rec.Code != 200 || etag == "" {
+			t.Fatalf("%s: got %d with ETag %q", target, rec.Code, etag)
+		}
+		if rec := get(mux, target, "If-None-Match", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%s with its ETag: got %d %q", target, rec.Code, rec.Body.String())
+		}
+		if rec := get(mux, target, "If-None-Match", `W/"other"`); rec.Code != 200 {
+			t.Errorf("%s with another ETag: got %d", target, rec.Code)
+		}
+	}
+
+	before := get(mux, "/api/titles").Header().Get("ETag")
+	if err := h.reload(); err != nil {
+		t.Fatal(err)
+	}
+	rec := get(mux, "/api/titles", "If-None-Match", before)
+	if rec.Code != 200 || rec.Header().Get("ETag") == before {
+		t.Errorf("after reload: got %d with ETag %q, want 200 and a new ETag", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestServeComplete(t *testing.T) {
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	wikiRoutes(mux, "", h)
+	tests := []struct {
+		target string
+		status int
+		count  int
+		first  string
+	}{
+		{"/api/complete?q=New+Yo", 200, 1, "New York City"},
+		{"/api/complete?q=new_Yo", 200, 1, "New York City"},
+		{"/api/complete?q=Sample", 200, defaultCompleteLimit, "Sample 001"},
+		{"/api/complete?q=Sample+05&limit=3", 200, 3, "Sample 050"},
+		{"/api/complete?q=Sample&limit=1000", 200, maxCompleteLimit, "Sample 001"},
+		{"/api/complete?q=Zzz", 200, 0, ""},
+		{"/api/complete?q=Sample&limit=0", 400, 0, ""},
+	}
+	for _, tt := range tests {
+		rec := get(mux, tt.target)
+		var titles []string
+		if rec.Code == 200 {
+			if err := json.Unmarshal(rec.Body.Bytes(), &titles); err != nil {
+				t.Fatalf("%s: %v", tt.target, err)
+			}
+		}
+		if rec.Code != tt.status || len(titles) != tt.count || (tt.count > 0 && titles[0] != tt.first) {
+			t.Errorf("%s: got %d %q, want %d with %d titles starting with %q", tt.target, rec.Code, titles, tt.status, tt.count, tt.first)
+		}
+	}
+}
+
+func TestCompleteLowercaseTitles(t *testing.T) {
+	indexPath, contentPath := writeGzipDump(t, "EBay", "A", "EBook", "B", "eBay", "C", "eBay Motors", "D", "eBook", "E")
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	mux := http.NewServeMux()
+	wikiRoutes(mux, "", h)
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/api/complete?q=eBay", `["EBay","eBay","eBay Motors"]`},
+		{"/api/complete?q=eBay_", `["eBay Motors"]`},
+		{"/api/complete?q=EBay", `["EBay"]`},
+		{"/api/complete?q=e", `["EBay","EBook","eBay","eBay Motors","eBook"]`},
+		{"/api/complete?q=e&limit=4", `["EBay","EBook","eBay","eBay Motors"]`},
+		{"/api/suggest?q=eBo", `["eBo",["EBook","eBook"]]`},
+	}
+	for _, tt := range tests {
+		if rec := get(mux, tt.target); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != tt.want {
+			t.Errorf("%s: got %d %q, want %s", tt.target, rec.Code, rec.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestServeById(t *testing.T) {
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	wikiRoutes(mux, "", h)
+	if h.data.Load().byId != nil {
+		t.Error("ids mapped to titles before the first lookup")
+	}
+	tests := []struct {
+		target   string
+		status   int
+		location string
+	}{
+		{"/api/byid/10", http.StatusFound, "/wiki/Alan_Turing"},
+		{"/api/byid/22", http.StatusFound, "/wiki/%C3%89clair"},
+		{"/api/byid/999", http.StatusNotFound, ""},
+		{"/api/byid/ten", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rec := get(mux, tt.target)
+		if rec.Code != tt.status || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s: got %d to %q, want %d to %q", tt.target, rec.Code, rec.Header().Get("Location"), tt.status, tt.location)
+		}
+	}
+}
+
+func TestServeTitlesPages(t *testing.T) {
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	wikiRoutes(mux, "", h)
+	var titles []string
+	pages := 0
+	for offset := 0; ; {
+		pages++
+		rec := get(mux, fmt.Sprintf("/api/titles?offset=%d&limit=30", offset))
+		var page titlesJSON
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("offset %d: got %d %q", offset, rec.Code, rec.Body.String())
+		}
+		if page.Total != h.index().Len() {
+			t.Errorf("offset %d: got total %d, want %d", offset, page.Total, h.index().Len())
+		}
+		titles = append(titles, page.Titles...)
+		if page.Next == 0 {
+			break
+		}
+		if page.Next != offset+30 {
+			t.Fatalf("offset %d: got next %d, want %d", offset, page.Next, offset+30)
+		}
+		offset = page.Next
+	}
+	if pages != 4 || len(titles) != h.index().Len() || len(slices.Compact(slices.Clone(titles))) != len(titles) {
+		t.Errorf("got %d titles on %d pages, want all %d once each on 4", len(titles), pages, h.index().Len())
+	}
+	for i, title := range titles {
+		if title != h.index().Title(i) {
+			t.Fatalf("title %d: got %q, want %q", i, title, h.index().Title(i))
+		}
+	}
+
+	tests := []struct {
+		target string
+		status int
+		want   string
+	}{
+		{"/api/titles?offset=1000", http.StatusOK, `{"titles":[],"total":107}`},
+		{"/api/titles?offset=105", http.StatusOK, `{"titles":["Sample 100","Éclair"],"total":107}`},
+		{"/api/titles?limit=1", http.StatusOK, `{"titles":["Alan Turing"],"total":107,"next":1}`},
+		{"/api/titles?offset=-1", http.StatusBadRequest, "invalid offset"},
+		{"/api/titles?limit=0", http.StatusBadRequest, "invalid limit"},
+		{"/api/titles?limit=a", http.StatusBadRequest, "invalid limit"},
+	}
+	for _, tt := range tests {
+		rec := get(mux, tt.target)
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%s: got %d %q, want %d containing %q", tt.target, rec.Code, rec.Body.String(), tt.status, tt.want)
+		}
+	}
+}
+
+func TestMissingTitle(t *testing.T) {
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	mux.Handle("/wiki/", wikiRoute(h))
+	wikiRoutes(mux, "", h)
+	mux.Handle("/", staticHandler("static"))
+	tests := []struct {
+		target   string
+		status   int
+		location string
+		body     string
+	}{
+		{"/wiki/", http.StatusFound, "/", ""},
+		{"/wiki/%20", http.StatusFound, "/", ""},
+		{"/api/article/", http.StatusBadRequest, "", "missing title"},
+		{"/api/meta/", http.StatusBadRequest, "", "missing title"},
+		{"/api/summary/%20", http.StatusBadRequest, "", "missing title"},
+		{"/text/", http.StatusBadRequest, "", "missing title"},
+	}
+	for _, tt := range tests {
+		rec := get(mux, tt.target)
+		if rec.Code != tt.status || rec.Header().Get("Location") != tt.location || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("%s: got %d to %q with %q, want %d to %q containing %q", tt.target, rec.Code, rec.Header().Get("Location"), rec.Body.String(), tt.status, tt.location, tt.body)
+		}
+	}
+	if rec := get(mux, "/favicon.ico"); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("/favicon.ico: got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errStaleBacklinks = errors.New("backlinks index is stale")
+
+// backlinkIndex maps the titles that are linked to the articles linking to
+// them and the categories to their members ordered by sort key. Articles are
+// stored once and referenced by their position in Titles.
+type backlinkIndex struct {
+	SourceSize    int64
+	SourceModTime time.Time
+	Titles        []string
+	Links         map[string][]uint32
+	Categories    map[string][]uint32
+}
+
+type backlinksJSON struct {
+	Title     string   `json:"title"`
+	Backlinks []string `json:"backlinks"`
+}
+
+type categoryJSON struct {
+	Category string   `json:"category"`
+	Members  []string `json:"members"`
+}
+
+// buildBacklinkIndex records the links and categories of every article of
+// the dump at multiStreamPath that is part of index. Links of redirects are
+// left out since they aren't links a reader follows.
+func buildBacklinkIndex(multiStreamPath string, index titleIndex) (*backlinkIndex, error) {
+	type member struct {
+		sortKey string
+		article uint32
+	}
+	backlinks := &backlinkIndex{Links: make(map[string][]uint32), Categories: make(map[string][]uint32)}
+	members := make(map[string][]member)
+	info, err := scanArticles(multiStreamPath, index, func(page *wikiPage) {
+		if _, ok := redirectTarget(page.Text); ok {
+			return
+		}
+		article := uint32(len(backlinks.Titles))
+		backlinks.Titles = append(backlinks.Titles, page.Title)
+		for _, target := range linkTargets(page.Text) {
+			backlinks.Links[target] = append(backlinks.Links[target], article)
+		}
+		for _, category := range categoryLinks(page.Text) {
+			sortKey := category.SortKey
+			if sortKey == "" {
+				sortKey = page.Title
+			}
+			members[category.Name] = append(members[category.Name], member{sortKey, article})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, sorted := range members {
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].sortKey < sorted[j].sortKey })
+		articles := make([]uint32, len(sorted))
+		for i, m := range sorted {
+			articles[i] = m.article
+		}
+		backlinks.Categories[name] = articles
+	}
+	backlinks.SourceSize, backlinks.SourceModTime = info.Size(), info.ModTime()
+	return backlinks, nil
+}
+
+// titles returns the titles of the articles that are still in index, which
+// leaves out blocked titles like the search does.
+func (b *backlinkIndex) titles(articles []uint32, index titleIndex) []string {
+	titles := make([]string, 0, len(articles))
+	for _, article := range articles {
+		if _, ok := index.Lookup(b.Titles[article]); ok {
+			titles = append(titles, b.Titles[article])
+		}
+	}
+	return titles
+}
+
+func loadBacklinks(backlinksPath string, source os.FileInfo) (*backlinkIndex, error) {
+	var backlinks backlinkIndex
+	if err := readGob(backlinksPath, &backlinks); err != nil {
+		return nil, err
+	}
+	if backlinks.SourceSize != source.Size() || !backlinks.SourceModTime.Equal(source.ModTime()) {
+		return nil, errStaleBacklinks
+	}
+	return &backlinks, nil
+}
+
+// startBacklinks makes backlinks available using the index at backlinksPath.
+// Like the search index it is rebuilt in the background if build is set and
+// it is missing or outdated.
+func (h *TinyWikiHandler) startBacklinks(backlinksPath string, build bool) {
+	info, err := statContent(h.contentFilePath)
+	if err != nil {
+		logError("Backlinks disabled:", err)
+		return
+	}
+	backlinks, err := loadBacklinks(backlinksPath, info)
+	if err == nil {
+		logInfo("Loaded backlinks", backlinksPath)
+		h.backlinks.Store(backlinks)
+		return
+	}
+	if !os.IsNotExist(err) {
+		logInfo("Ignoring backlinks:", err)
+	}
+	if !build {
+		return
+	}
+	h.backlinksBuild.start(func() {
+		logInfo("Building backlinks", backlinksPath)
+		backlinks, err := buildBacklinkIndex(h.contentFilePath, h.index())
+		if err != nil {
+			logError("Couldn't build backlinks:", err)
+			return
+		}
+		h.backlinks.Store(backlinks)
+		logInfo("Backlinks built with", len(backlinks.Links), "linked titles and", len(backlinks.Categories), "categories")
+		if err := writeGob(backlinksPath, backlinks); err != nil {
+			logError("Couldn't write backlinks:", err)
+		}
+	})
+}
+
+// ServeBacklinksJSON serves the titles of the articles linking to the title
+// named by the request path. It is only available once the backlinks have
+// been loaded or built.
+func (h *TinyWikiHandler) ServeBacklinksJSON(w http.ResponseWriter, r *http.Request) {
+	if !checkTitle(w, r, writeJSONError) {
+		return
+	}
+	backlinks := h.backlinks.Load()
+	if backlinks == nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorJSON{"backlinks not available"})
+		return
+	}
+	title := normalizeTitle(strings.TrimSpace(r.URL.Path))
+	noteTitle(r, title)
+	writeJSON(w, http.StatusOK, backlinksJSON{title, backlinks.titles(backlinks.Links[title], h.index())})
+}
+
+// ServeCategoryJSON serves the members of the category named by the request
+// path with or without the Category: prefix. Like the backlinks it needs the
+// index built by -buildbacklinks.
+func (h *TinyWikiHandler) ServeCategoryJSON(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.URL.Path)
+	if hasPrefixFold(name, "Category:") {
+		name = strings.TrimSpace(name[len("Category:"):])
+	}
+	if name == "" {
+		writeJSON(w, http.StatusBadRequest, errorJSON{"missing category"})
+		return
+	}
+	backlinks := h.backlinks.Load()
+	if backlinks == nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorJSON{"categories not available"})
+		return
+	}
+	name = normalizeTitle(name)
+	members, ok := backlinks.Categories[name]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorJSON{"not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, categoryJSON{name, backlinks.titles(members, h.index())})
+}
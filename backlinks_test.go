@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newBacklinksHandler returns a handler with the fixture's backlinks and one
+// loaded with Alan Turing blocked but the same backlinks, as if they were
+// built before he was added to the blocklist.
+func newBacklinksHandler(t *testing.T) (full, blocked *TinyWikiHandler) {
+	full = newTestHandler(t)
+	backlinks, err := buildBacklinkIndex(testContentPath, full.index())
+	if err != nil {
+		t.Fatal(err)
+	}
+	full.backlinks.Store(backlinks)
+	blocklist := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(blocklist, []byte("Alan Turing\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	blocklistPath = blocklist
+	defer func() { blocklistPath = "" }()
+	blocked = loadTestWiki(t, testIndexPath, testContentPath, defaultLinkBase)
+	blocked.backlinks.Store(backlinks)
+	return full, blocked
+}
+
+func apiRoutes(h *TinyWikiHandler) *http.ServeMux {
+	mux := http.NewServeMux()
+	wikiRoutes(mux, "", h)
+	return mux
+}
+
+func TestServeBacklinksJSON(t *testing.T) {
+	full, blocked := newBacklinksHandler(t)
+	tests := []struct {
+		h      *TinyWikiHandler
+		target string
+		want   backlinksJSON
+	}{
+		{full, "/Bletchley_Park", backlinksJSON{"Bletchley Park", []string{"Alan Turing"}}},
+		{full, "/mercury_(planet)", backlinksJSON{"Mercury (planet)", []string{"Mercury"}}},
+		{full, "/Unlinked", backlinksJSON{"Unlinked", []string{}}},
+		{blocked, "/Bletchley_Park", backlinksJSON{"Bletchley Park", []string{}}},
+		{blocked, "/Mercury_(planet)", backlinksJSON{"Mercury (planet)", []string{"Mercury"}}},
+	}
+	for _, tt := range tests {
+		rec := get(apiRoutes(tt.h), "/api/backlinks"+tt.target)
+		var got backlinksJSON
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %q", tt.target, rec.Code, rec.Body.String())
+		}
+		if got.Title != tt.want.Title || !slices.Equal(got.Backlinks, tt.want.Backlinks) {
+			t.Errorf("%s: got %+v, want %+v", tt.target, got, tt.want)
+		}
+	}
+	if rec := get(apiRoutes(newTestHandler(t)), "/api/backlinks/Bletchley_Park"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without backlinks: got %d", rec.Code)
+	}
+}
+
+func TestServeCategoryJSON(t *testing.T) {
+	full, blocked := newBacklinksHandler(t)
+	tests := []struct {
+		h      *TinyWikiHandler
+		target string
+		status int
+		want   categoryJSON
+	}{
+		{full, "/Mathematicians", http.StatusOK, categoryJSON{"Mathematicians", []string{"Alan Turing"}}},
+		{full, "/Category:1912_births", http.StatusOK, categoryJSON{"1912 births", []string{"Alan Turing"}}},
+		{full, "/category:_mathematicians", http.StatusOK, categoryJSON{"Mathematicians", []string{"Alan Turing"}}},
+		{blocked, "/Mathematicians", http.StatusOK, categoryJSON{"Mathematicians", []string{}}},
+		{full, "/Physicists", http.StatusNotFound, categoryJSON{}},
+		{full, "/Category:", http.StatusBadRequest, categoryJSON{}},
+	}
+	for _, tt := range tests {
+		rec := get(apiRoutes(tt.h), "/api/category"+tt.target)
+		if rec.Code != tt.status {
+			t.Errorf("%s: got %d %q, want %d", tt.target, rec.Code, rec.Body.String(), tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var got categoryJSON
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v in %q", tt.target, err, rec.Body.String())
+		}
+		if got.Category != tt.want.Category || !slices.Equal(got.Members, tt.want.Members) {
+			t.Errorf("%s: got %+v, want %+v", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync"
+)
+
+const (
+	// maxBatchTitles bounds the number of articles fetched by one batch
+	// request
+	maxBatchTitles = 50
+	// maxBatchBodyBytes bounds the size of a batch request's body
+	maxBatchBodyBytes = 64 << 10
+)
+
+// batchArticleJSON is an article of a batch response. Content and Truncated
+// are left out for titles which weren't found, Error is only set if the
+// article couldn't be extracted.
+type batchArticleJSON struct {
+	Title     string `json:"title"`
+	Found     bool   `json:"found"`
+	Content   string `json:"content,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ServeBatchJSON fetches the articles named by a JSON array of titles posted
+// to it and serves them in the same order. As many articles as there are CPUs
+// are extracted at a time.
+func (h *TinyWikiHandler) ServeBatchJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, errorJSON{"method not allowed"})
+		return
+	}
+	var titles []string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)).Decode(&titles); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorJSON{"expected a JSON array of titles"})
+		return
+	}
+	if len(titles) > maxBatchTitles {
+		writeJSON(w, http.StatusBadRequest, errorJSON{"too many titles"})
+		return
+	}
+	articles := make([]batchArticleJSON, len(titles))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(runtime.GOMAXPROCS(0), len(titles)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				articles[i] = h.batchArticle(r, titles[i])
+			}
+		}()
+	}
+	for i := range titles {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	writeJSON(w, http.StatusOK, articles)
+}
+
+// batchArticle looks up a single title of a batch request. Invalid titles
+// are reported as not found.
+func (h *TinyWikiHandler) batchArticle(r *http.Request, rawTitle string) batchArticleJSON {
+	if err := validateTitle(rawTitle); err != nil {
+		return batchArticleJSON{Title: rawTitle}
+	}
+	title, _, content, err := h.lookup(r.Context(), rawTitle)
+	if err == errArticleNotFound {
+		return batchArticleJSON{Title: title}
+	}
+	if err != nil {
+		logError(err)
+		_, message := extractionErrorStatus(err)
+		return batchArticleJSON{Title: title, Found: true, Error: message}
+	}
+	content, truncated := truncateContent(content, h.maxBytes)
+	return batchArticleJSON{Title: title, Found: true, Content: content, Truncated: truncated}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postBatch(h *TinyWikiHandler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeBatchJSON(rec, httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(body)))
+	return rec
+}
+
+func TestServeBatchJSON(t *testing.T) {
+	h := newTestHandler(t)
+	rec := postBatch(h, `["Alan_Turing", "NYC", "Missing article", "Bad[title]"]`)
+	var got []batchArticleJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+	// A redirect is served as it is like by /api/article/
+	want := []struct {
+		title, content string
+		found          bool
+	}{
+		{"Alan Turing", "'''Alan Mathison Turing''' was an English", true},
+		{"NYC", "#REDIRECT [[New York City]]", true},
+		{"Missing article", "", false},
+		{"Bad[title]", "", false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d articles, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].Title != w.title || got[i].Found != w.found || !strings.Contains(got[i].Content, w.content) || got[i].Error != "" {
+			t.Errorf("article %d: got %+v, want %s found %v with %q", i, got[i], w.title, w.found, w.content)
+		}
+	}
+	if strings.Count(rec.Body.String(), `"content"`) != 2 {
+		t.Errorf("content sent for articles not found: %q", rec.Body.String())
+	}
+
+	tooMany := `["` + strings.Repeat(`Sample 001", "`, maxBatchTitles) + `Sample 002"]`
+	for body, status := range map[string]int{
+		`[]`:            http.StatusOK,
+		`"Alan Turing"`: http.StatusBadRequest,
+		`[`:             http.StatusBadRequest,
+		tooMany:         http.StatusBadRequest,
+	} {
+		if rec := postBatch(h, body); rec.Code != status {
+			t.Errorf("posting %.40q: got %d %q, want %d", body, rec.Code, rec.Body.String(), status)
+		}
+	}
+	rec = httptest.NewRecorder()
+	h.ServeBatchJSON(rec, httptest.NewRequest(http.MethodGet, "/api/batch", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET: got %d allowing %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
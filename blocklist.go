@@ -0,0 +1,34 @@
+package main
+
+// loadBlocklist reads the titles to hide from the file at blocklistPath, one
+// per line, normalized like requested titles
+func loadBlocklist(blocklistPath string) (map[string]bool, error) {
+	titles, err := readTitleList(blocklistPath)
+	if err != nil {
+		return nil, err
+	}
+	blocked := make(map[string]bool, len(titles))
+	for _, title := range titles {
+		blocked[normalizeTitle(title)] = true
+	}
+	return blocked, nil
+}
+
+// dropBlocked removes the titles listed in the file at blocklistPath from
+// offsetMap. Without an index entry they are answered with 404 and never
+// show up in completions, searches or as random article.
+func dropBlocked(offsetMap map[string]OffsetAndId, blocklistPath string) error {
+	blocked, err := loadBlocklist(blocklistPath)
+	if err != nil {
+		return err
+	}
+	dropped := 0
+	for title := range offsetMap {
+		if blocked[normalizeTitle(title)] {
+			delete(offsetMap, title)
+			dropped++
+		}
+	}
+	logInfo("Blocked", dropped, "of", len(blocked), "titles in", blocklistPath)
+	return nil
+}
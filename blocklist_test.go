@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlocklist(t *testing.T) {
+	full := newTestHandler(t)
+	blocklist := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(blocklist, []byte("Alan_Turing\nsample 001\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	blocklistPath = blocklist
+	defer func() { blocklistPath = "" }()
+	h := loadTestWiki(t, testIndexPath, testContentPath, defaultLinkBase)
+	routes := wikiRoute(h)
+
+	if rec := get(routes, "/wiki/Alan_Turing"); rec.Code != 404 {
+		t.Errorf("blocked article: got %d", rec.Code)
+	}
+	rec := get(http.HandlerFunc(h.ServeComplete), "/api/complete?prefix=Sample_00")
+	if strings.Contains(rec.Body.String(), "Sample 001") || !strings.Contains(rec.Body.String(), "Sample 002") {
+		t.Errorf("completions: got %q", rec.Body.String())
+	}
+	search, err := buildSearchIndex(testContentPath, full.index())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasResult(search.query("Turing", 10, full.index()), "Alan Turing") {
+		t.Fatal("Alan Turing not found without blocklist")
+	}
+	if hasResult(search.query("Turing", 10, h.index()), "Alan Turing") {
+		t.Error("search returned blocked Alan Turing")
+	}
+
+	// The list is read again on reload
+	if err := os.WriteFile(blocklist, []byte("Sample 001\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(routes, "/wiki/Alan_Turing"); rec.Code != 200 {
+		t.Errorf("unblocked article after reload: got %d", rec.Code)
+	}
+	if rec := get(routes, "/wiki/Sample_001"); rec.Code != 404 {
+		t.Errorf("still blocked article after reload: got %d", rec.Code)
+	}
+}
+
+func hasResult(results []searchResult, title string) bool {
+	for _, result := range results {
+		if result.Title == title {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var (
+	errNotBzip2Multistream = errors.New("only bzip2 multistream dumps can be indexed")
+	errBzip2Output         = errors.New("can't write bzip2 compressed indexes, use .gz or no extension")
+)
+
+// bzip2Magic starts every bzip2 stream and is followed by the block size digit
+// and blockMagic, the magic number of the stream's first block
+var (
+	bzip2Magic = []byte("BZh")
+	blockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+)
+
+// isStreamStart reports whether b starts with the header of a bzip2 stream
+func isStreamStart(b []byte) bool {
+	return len(b) >= 4+len(blockMagic) && bytes.HasPrefix(b, bzip2Magic) &&
+		b[3] >= '1' && b[3] <= '9' && bytes.HasPrefix(b[4:], blockMagic)
+}
+
+// findStreams returns the offsets of all bzip2 streams in the dump. The
+// header could in theory also appear inside compressed data but with 80 fixed
+// bits that is far too unlikely to matter even for the largest dumps.
+func findStreams(dump io.ReaderAt, size int64) ([]int64, error) {
+	var offsets []int64
+	headerSize := 4 + len(blockMagic)
+	// Blocks overlap by the header size so headers crossing a block
+	// boundary are found as well
+	block := make([]byte, remoteBlockSize+headerSize-1)
+	for start := int64(0); start < size; start += remoteBlockSize {
+		n, err := dump.ReadAt(block, start)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		for i := 0; i < n && i < remoteBlockSize; i++ {
+			next := bytes.Index(block[i:n], bzip2Magic)
+			if next < 0 {
+				break
+			}
+			i += next
+			if i < remoteBlockSize && isStreamStart(block[i:n]) {
+				offsets = append(offsets, start+int64(i))
+			}
+		}
+	}
+	return offsets, nil
+}
+
+// buildIndex reconstructs the multistream index of the bzip2 compressed dump
+// at multiStreamPath and writes it to out in the offset:id:title format of the
+// index files, for a split dump prefixed with the name of the stream file.
+// Every stream is decompressed but only up to the ids of its pages.
+func buildIndex(multiStreamPath string, out io.Writer) (pages int, err error) {
+	if compressionExt(multiStreamPath) != ".bz2" {
+		return 0, errNotBzip2Multistream
+	}
+	multiStream, err := openContent(multiStreamPath)
+	if err != nil {
+		return 0, err
+	}
+	defer multiStream.Close()
+	info, err := multiStream.Stat()
+	if err != nil {
+		return 0, err
+	}
+	offsets, err := findStreams(multiStream, info.Size())
+	if err != nil {
+		return 0, err
+	}
+	location := func(offset int64) string {
+		return strconv.FormatInt(offset, 10)
+	}
+	if split, ok := multiStream.(*splitDump); ok {
+		location = func(offset int64) string {
+			name, fileOffset := split.locate(offset)
+			return name + ":" + strconv.FormatInt(fileOffset, 10)
+		}
+	}
+	for i, offset := range offsets {
+		end := info.Size()
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+		// Only the headers of the pages are needed so none of them is
+		// wanted and the rest of each page is skipped
+		var writeErr error
+		stream := bzip2.NewReader(bufio.NewReader(io.NewSectionReader(multiStream, offset, end-offset)))
+		err := scanPages(stream, func(page *wikiPage) bool {
+			if writeErr == nil {
+				_, writeErr = fmt.Fprintf(out, "%s:%d:%s\n", location(offset), page.Id, page.Title)
+				pages++
+			}
+			return false
+		}, nil)
+		if writeErr != nil {
+			return pages, writeErr
+		}
+		if err != nil {
+			return pages, fmt.Errorf("indexing stream at offset %d: %w", offset, err)
+		}
+	}
+	return pages, nil
+}
+
+// writeIndexFile writes the index reconstructed from the dump at
+// multiStreamPath to indexPath, gzip compressed if it ends in .gz
+func writeIndexFile(multiStreamPath, indexPath string) (pages int, err error) {
+	ext := filepath.Ext(indexPath)
+	if ext == ".bz2" {
+		return 0, errBzip2Output
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(indexPath), filepath.Base(indexPath)+".tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	buffered := bufio.NewWriter(tmpFile)
+	out := io.Writer(buffered)
+	var compressed *gzip.Writer
+	if ext == ".gz" {
+		compressed = gzip.NewWriter(buffered)
+		out = compressed
+	}
+	if pages, err = buildIndex(multiStreamPath, out); err != nil {
+		return pages, err
+	}
+	if compressed != nil {
+		if err := compressed.Close(); err != nil {
+			return pages, err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return pages, err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return pages, err
+	}
+	return pages, os.Rename(tmpFile.Name(), indexPath)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache[string, int](2)
+	c.add("a", 1)
+	c.add("b", 2)
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a missing before eviction")
+	}
+	c.add("c", 3)
+	if _, ok := c.get("b"); ok {
+		t.Error("b survived although it was used least recently")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if got, ok := c.get(key); !ok || got != want {
+			t.Errorf("%s: got %d, %t, want %d", key, got, ok, want)
+		}
+	}
+	if c.len() != 2 {
+		t.Errorf("got %d entries, want 2", c.len())
+	}
+
+	disabled := newLRUCache[string, int](0)
+	disabled.add("a", 1)
+	if _, ok := disabled.get("a"); ok {
+		t.Error("cache of capacity zero kept an entry")
+	}
+}
+
+func TestArticleCacheServesWithoutDump(t *testing.T) {
+	dir := t.TempDir()
+	indexPath, contentPath := filepath.Join(dir, "index.txt.bz2"), filepath.Join(dir, "dump.xml.bz2")
+	copyFile(t, testIndexPath, indexPath)
+	copyFile(t, testContentPath, contentPath)
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	h.data.Store(newWikiData(h.index(), h.data.Load().dump, 10, 0))
+	routes := wikiRoute(h)
+
+	first := get(routes, "/wiki/Alan_Turing?action=raw")
+	if first.Code != http.StatusOK {
+		t.Fatalf("got %d %q", first.Code, first.Body.String())
+	}
+	if err := os.Remove(contentPath); err != nil {
+		t.Fatal(err)
+	}
+	hits := metrics.cacheHits.Load()
+	cached := get(routes, "/wiki/Alan_Turing?action=raw")
+	if cached.Code != http.StatusOK || cached.Body.String() != first.Body.String() {
+		t.Errorf("cached: got %d %.60q, want %.60q", cached.Code, cached.Body.String(), first.Body.String())
+	}
+	if metrics.cacheHits.Load() == hits {
+		t.Error("second request didn't count as cache hit")
+	}
+	if rec := get(routes, "/wiki/New_York_City?action=raw"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("uncached article without dump: got %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// chunkCache is a least recently used cache of decompressed streams of a
+// multistream dump keyed by their offset. Each stream holds about a hundred
+// pages so neighbouring articles are served without decompressing their
+// stream again. The cache is bounded by the total size of the streams it
+// holds, a size of zero disables it.
+type chunkCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[int64]*list.Element
+	order    *list.List
+}
+
+type chunkCacheEntry struct {
+	offset int64
+	data   []byte
+}
+
+func newChunkCache(maxBytes int64) *chunkCache {
+	return &chunkCache{
+		maxBytes: maxBytes,
+		entries:  make(map[int64]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *chunkCache) enabled() bool {
+	return c.maxBytes > 0
+}
+
+// bytes returns the total size of the cached streams
+func (c *chunkCache) bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *chunkCache) get(offset int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[offset]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*chunkCacheEntry).data, true
+}
+
+func (c *chunkCache) add(offset int64, data []byte) {
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[offset]; ok {
+		return
+	}
+	c.entries[offset] = c.order.PushFront(&chunkCacheEntry{offset, data})
+	c.size += int64(len(data))
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		entry := oldest.Value.(*chunkCacheEntry)
+		delete(c.entries, entry.offset)
+		c.size -= int64(len(entry.data))
+	}
+}
+
+// readChunk decompresses the stream of dump between offset and end. An end of
+// -1 reads up to the end of the file.
+func readChunk(ctx context.Context, dump dumpSource, offset, end int64) ([]byte, error) {
+	contentStream, multiStream, err := openStream(ctx, dump, offset, end)
+	if err != nil {
+		return nil, err
+	}
+	defer multiStream.Close()
+	data, err := io.ReadAll(contentStream)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing stream at offset %d: %w", offset, err)
+	}
+	return data, nil
+}
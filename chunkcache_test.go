@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChunkCacheSkipsDecompression(t *testing.T) {
+	dir := t.TempDir()
+	indexPath, contentPath := filepath.Join(dir, "index.txt.bz2"), filepath.Join(dir, "dump.xml.bz2")
+	copyFile(t, testIndexPath, indexPath)
+	copyFile(t, testContentPath, contentPath)
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	data := newWikiData(h.index(), h.data.Load().dump, 0, 1<<20)
+	h.data.Store(data)
+	routes := wikiRoute(h)
+
+	if rec := get(routes, "/wiki/Alan_Turing?action=raw"); rec.Code != http.StatusOK {
+		t.Fatalf("Alan Turing: got %d %q", rec.Code, rec.Body.String())
+	}
+	size := data.chunks.bytes()
+	if size == 0 {
+		t.Fatal("stream of Alan Turing not cached")
+	}
+	// New York City is in the same stream, which is read from the cache
+	// even with the dump gone
+	if err := os.Remove(contentPath); err != nil {
+		t.Fatal(err)
+	}
+	hits := metrics.chunkCacheHits.Load()
+	rec := get(routes, "/wiki/New_York_City?action=raw")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "'''New York City''' is a city") {
+		t.Errorf("New York City: got %d %.60q", rec.Code, rec.Body.String())
+	}
+	if got := metrics.chunkCacheHits.Load() - hits; got != 1 {
+		t.Errorf("got %d chunk cache hits, want 1", got)
+	}
+	if data.chunks.bytes() != size {
+		t.Errorf("cache grew from %d to %d bytes serving a cached stream", size, data.chunks.bytes())
+	}
+	if rec := get(routes, "/wiki/Mercury?action=raw"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Mercury in an uncached stream: got %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestChunkCacheBoundedBySize(t *testing.T) {
+	c := newChunkCache(10)
+	c.add(1, make([]byte, 4))
+	c.add(2, make([]byte, 4))
+	c.get(1)
+	c.add(3, make([]byte, 4))
+	if _, ok := c.get(2); ok {
+		t.Error("least recently used stream 2 not evicted")
+	}
+	if _, ok := c.get(1); !ok {
+		t.Error("stream 1 evicted although it was used recently")
+	}
+	c.add(4, make([]byte, 11))
+	if _, ok := c.get(4); ok {
+		t.Error("stream larger than the cache was cached")
+	}
+	if c.bytes() != 8 {
+		t.Errorf("got %d bytes cached, want 8", c.bytes())
+	}
+}
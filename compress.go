@@ -0,0 +1,58 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"net/url"
+	"path/filepath"
+)
+
+// decompressor wraps a reader positioned at the start of a compressed stream.
+// All supported formats allow concatenating streams so seeking to a stream
+// offset and decompressing from there works the same for each of them.
+type decompressor func(io.Reader) (io.Reader, error)
+
+func bzip2Decompressor(r io.Reader) (io.Reader, error) {
+	return bzip2.NewReader(r), nil
+}
+
+func gzipDecompressor(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+func plainDecompressor(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+// decompressorFor picks the decompressor for a dump file by its extension.
+// Files without a known compression extension are read as is.
+func decompressorFor(path string) (decompressor, error) {
+	switch compressionExt(path) {
+	case ".bz2":
+		return bzip2Decompressor, nil
+	case ".gz":
+		return gzipDecompressor, nil
+	default:
+		return plainDecompressor, nil
+	}
+}
+
+// isCompressed reports whether the dump file at path is compressed in one of
+// the supported formats
+func isCompressed(path string) bool {
+	ext := compressionExt(path)
+	return ext == ".bz2" || ext == ".gz"
+}
+
+// compressionExt returns the extension of the dump file at path, which may
+// also be a URL with a query. Split dumps consist of bzip2 streams.
+func compressionExt(path string) string {
+	if isSplitDump(path) {
+		return ".bz2"
+	}
+	if u, err := url.Parse(path); err == nil && isURL(path) {
+		path = u.Path
+	}
+	return filepath.Ext(path)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressionExt(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"enwiki-pages-articles-multistream.xml.bz2", ".bz2"},
+		{"dump.xml.gz", ".gz"},
+		{"dump.xml", ".xml"},
+		{"https://example.org/dump.xml.bz2?download=1", ".bz2"},
+		{"testdata/split", ".bz2"},
+	}
+	for _, tt := range tests {
+		if got := compressionExt(tt.path); got != tt.want {
+			t.Errorf("compressionExt(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExtractCompressedDumps(t *testing.T) {
+	dumps := []struct {
+		name, indexPath, contentPath string
+	}{
+		{"bzip2", testIndexPath, testContentPath},
+		{"gzip", "testdata/gzip-index.txt.bz2", "testdata/gzip.xml.gz"},
+	}
+	for _, dump := range dumps {
+		t.Run(dump.name, func(t *testing.T) {
+			h := loadTestWiki(t, dump.indexPath, dump.contentPath, defaultLinkBase)
+			for title, want := range map[string]string{
+				"Alan_Turing": "was a '''mathematician'''",
+				"Éclair":      "is a pastry filled with cream",
+			} {
+				rec := get(wikiRoute(h), "/wiki/"+title+"?action=raw")
+				if rec.Code != 200 || !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("%s: got %d %q, want %q", title, rec.Code, rec.Body.String(), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+var (
+	displayTitleRegexp = regexp.MustCompile(`(?i)\{\{\s*DISPLAYTITLE\s*:\s*([^|}]*)`)
+	italicTitleRegexp  = regexp.MustCompile(`(?i)\{\{\s*italic[ _]?title\s*[|}]`)
+	htmlTagRegexp      = regexp.MustCompile(`<[^>]*>`)
+)
+
+// displayTitle returns how the article title with the given wikitext wants
+// its title shown, as plain text and as HTML for its heading. It is set by
+// {{DISPLAYTITLE:...}}, which like in MediaWiki is only honored if it
+// normalizes to title, or italicized by {{italic title}} leaving out a
+// disambiguation in parentheses. Otherwise title itself is returned. Links in
+// the heading point to articles below linkBase.
+func displayTitle(title, wikitext, linkBase string) (string, template.HTML) {
+	display := title
+	if m := displayTitleRegexp.FindStringSubmatch(wikitext); m != nil {
+		// Italics are the formatting display titles use most, others are
+		// dropped
+		value := strings.NewReplacer("<i>", "''", "</i>", "''", "<I>", "''", "</I>", "''").Replace(m[1])
+		value = strings.TrimSpace(htmlTagRegexp.ReplaceAllString(value, ""))
+		if value != "" && normalizeTitle(strings.TrimSpace(stripWikitext(value))) == normalizeTitle(title) {
+			display = value
+		}
+	} else if italicTitleRegexp.MatchString(wikitext) {
+		name, disambiguation := title, ""
+		if i := strings.LastIndex(title, " ("); i > 0 && strings.HasSuffix(title, ")") {
+			name, disambiguation = title[:i], title[i:]
+		}
+		display = "''" + name + "''" + disambiguation
+	}
+	return strings.TrimSpace(stripWikitext(display)), template.HTML(renderInline(display, linkBase))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDisplayTitle(t *testing.T) {
+	tests := []struct {
+		name, title, wikitext, display string
+		heading                        template.HTML
+	}{
+		{"none", "Alan Turing", "'''Alan Turing''' was", "Alan Turing", "Alan Turing"},
+		{"lowercase first letter", "IPod", "{{DISPLAYTITLE:iPod}}", "iPod", "iPod"},
+		{"italics", "Nature (journal)", "{{DISPLAYTITLE:<i>Nature</i> (journal)}}", "Nature (journal)", "<i>Nature</i> (journal)"},
+		{"wikitext italics", "Nature (journal)", "{{ displaytitle : ''Nature'' (journal) }}", "Nature (journal)", "<i>Nature</i> (journal)"},
+		{"other formatting dropped", "E=mc2", "{{DISPLAYTITLE:E=mc<sup>2</sup>}}", "E=mc2", "E=mc2"},
+		{"another title ignored", "Alan Turing", "{{DISPLAYTITLE:Alan the Great}}", "Alan Turing", "Alan Turing"},
+		{"other letters' case ignored", "Alan Turing", "{{DISPLAYTITLE:alan turing}}", "Alan Turing", "Alan Turing"},
+		{"empty ignored", "Alan Turing", "{{DISPLAYTITLE: }}", "Alan Turing", "Alan Turing"},
+		{"italic title", "Nature (journal)", "{{Italic title}}", "Nature (journal)", "<i>Nature</i> (journal)"},
+		{"italic title without disambiguation", "Nature", "{{italic_title|reason=x}}", "Nature", "<i>Nature</i>"},
+		{"escaped", "Tom & Jerry", `{{DISPLAYTITLE:''Tom & Jerry''}}`, "Tom & Jerry", "<i>Tom &amp; Jerry</i>"},
+		{"tags can't sneak in", "Tom & Jerry", "{{DISPLAYTITLE:Tom <script>alert(1)</script>& Jerry}}", "Tom & Jerry", "Tom &amp; Jerry"},
+		{"quotes escaped", `"Weird Al" Yankovic`, "{{Italic title}}", `"Weird Al" Yankovic`, "<i>&#34;Weird Al&#34; Yankovic</i>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			display, heading := displayTitle(tt.title, tt.wikitext, defaultLinkBase)
+			if display != tt.display || heading != tt.heading {
+				t.Errorf("displayTitle(%q, %q) = %q, %q, want %q, %q", tt.title, tt.wikitext, display, heading, tt.display, tt.heading)
+			}
+		})
+	}
+
+	indexPath, contentPath := writeGzipDump(t, "Tom & Jerry", "{{DISPLAYTITLE:''Tom <script>alert(1)</script>& Jerry''}}\n'''Tom & Jerry''' is a cartoon.",
+		"IPod", "{{DISPLAYTITLE:iPod}}\nA player.")
+	routes := wikiRoute(loadTestWiki(t, indexPath, contentPath, defaultLinkBase))
+	rec := get(routes, "/wiki/Tom_%26_Jerry")
+	if body := rec.Body.String(); rec.Code != http.StatusOK || strings.Contains(body, "<script>") || !strings.Contains(body, "<title>Tom &amp; Jerry") {
+		t.Errorf("Tom & Jerry: got %d %q", rec.Code, body)
+	}
+	rec = get(routes, "/wiki/IPod")
+	if body := rec.Body.String(); !strings.Contains(body, "<title>iPod") || !strings.Contains(body, ">iPod</h1>") {
+		t.Errorf("iPod: got %q", body)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the names of the environment variables flags can be set
+// with
+const envPrefix = "TINYPEDIA_"
+
+// envNames are the environment variables of flags too short to be readable
+// as one. TINYPEDIA_INDEX names the index file so -index needs another one.
+var envNames = map[string]string{
+	"i":     envPrefix + "INDEX",
+	"d":     envPrefix + "CONTENT",
+	"index": envPrefix + "INDEXKIND",
+}
+
+// envName returns the environment variable of the flag called name, e.g.
+// TINYPEDIA_CHUNKCACHE for -chunkcache
+func envName(name string) string {
+	if env, ok := envNames[name]; ok {
+		return env
+	}
+	return envPrefix + strings.ToUpper(name)
+}
+
+// envOr returns the value of the environment variable env, or of the one
+// named after the flag flagName if env is empty, and defaultVal if it isn't
+// set or empty
+func envOr(flagName, env, defaultVal string) string {
+	if env == "" {
+		env = envName(flagName)
+	}
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	return defaultVal
+}
+
+// splitWikiList splits the value of TINYPEDIA_WIKI into the values of -wiki.
+// They are separated like the paths of PATH since the paths of a wiki are
+// separated by a comma already. On Unix a separator that is followed by //
+// belongs to the scheme of a URL like http://host/index.txt.bz2 though.
+func splitWikiList(value string) []string {
+	var wikis []string
+	for _, part := range strings.Split(value, string(os.PathListSeparator)) {
+		if len(wikis) > 0 && os.PathListSeparator == ':' && strings.HasPrefix(part, "//") {
+			wikis[len(wikis)-1] += ":" + part
+			continue
+		}
+		wikis = append(wikis, part)
+	}
+	return wikis
+}
+
+// setFlagsFromEnv sets every flag not given on the command line from its
+// environment variable, if that is set. The repeatable -wiki takes a list
+// separated like PATH, see splitWikiList.
+func setFlagsFromEnv(flags *flag.FlagSet) error {
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		value := envOr(f.Name, "", "")
+		if given[f.Name] || value == "" || err != nil {
+			return
+		}
+		values := []string{value}
+		if f.Name == "wiki" {
+			values = splitWikiList(value)
+		}
+		for _, value := range values {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid %s: %w", envName(f.Name), setErr)
+				return
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSetFlagsFromEnv(t *testing.T) {
+	newFlags := func() (*flag.FlagSet, *int, *string, *wikiConfigs) {
+		flags := flag.NewFlagSet("tinypedia", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		port := flags.Int("port", 8080, "")
+		content := flags.String("d", "", "")
+		var wikis wikiConfigs
+		flags.Var(&wikis, "wiki", "")
+		return flags, port, content, &wikis
+	}
+	t.Setenv("TINYPEDIA_PORT", "9090")
+	t.Setenv("TINYPEDIA_CONTENT", "dump.xml.bz2")
+	sep := string(os.PathListSeparator)
+	t.Setenv("TINYPEDIA_WIKI", "de=my dumps/a,my dumps/b"+sep+"en=c,d")
+
+	flags, port, content, wikis := newFlags()
+	if err := flags.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := setFlagsFromEnv(flags); err != nil {
+		t.Fatal(err)
+	}
+	want := wikiConfigs{{"de", "my dumps/a", "my dumps/b"}, {"en", "c", "d"}}
+	if *port != 9090 || *content != "dump.xml.bz2" || !reflect.DeepEqual(*wikis, want) {
+		t.Fatalf("unset flags: got %d %q %v", *port, *content, *wikis)
+	}
+
+	flags, port, _, _ = newFlags()
+	if err := flags.Parse([]string{"-port", "7070"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := setFlagsFromEnv(flags); err != nil {
+		t.Fatal(err)
+	}
+	if *port != 7070 {
+		t.Fatalf("flag given on the command line: got %d, want 7070", *port)
+	}
+
+	// An empty variable leaves the default
+	t.Setenv("TINYPEDIA_PORT", "")
+	flags, port, _, _ = newFlags()
+	if err := flags.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := setFlagsFromEnv(flags); err != nil || *port != 8080 {
+		t.Fatalf("empty variable: got %d, %v", *port, err)
+	}
+
+	t.Setenv("TINYPEDIA_PORT", "many")
+	flags, _, _, _ = newFlags()
+	if err := flags.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := setFlagsFromEnv(flags); err == nil || !strings.Contains(err.Error(), "TINYPEDIA_PORT") {
+		t.Fatalf("invalid value: got %v", err)
+	}
+}
+
+func TestEnvOr(t *testing.T) {
+	t.Setenv("TINYPEDIA_CHUNKCACHE", "64")
+	t.Setenv("TINYPEDIA_INDEX", "index.txt.bz2")
+	t.Setenv("TINYPEDIA_STATIC", "")
+	tests := []struct {
+		flagName, env, defaultVal, want string
+	}{
+		{"chunkcache", "", "0", "64"},
+		{"i", "", "default.txt.bz2", "index.txt.bz2"},
+		{"d", "TINYPEDIA_INDEX", "", "index.txt.bz2"},
+		{"static", "", "static", "static"},
+		{"addr", "", ":8080", ":8080"},
+	}
+	for _, tt := range tests {
+		if got := envOr(tt.flagName, tt.env, tt.defaultVal); got != tt.want {
+			t.Errorf("envOr(%q, %q, %q) = %q, want %q", tt.flagName, tt.env, tt.defaultVal, got, tt.want)
+		}
+	}
+}
+
+func TestSplitWikiList(t *testing.T) {
+	if os.PathListSeparator != ':' {
+		t.Skip("URLs only need rejoining with : as separator")
+	}
+	got := splitWikiList("de=http://host/de-index.txt.bz2,https://host/de.xml.bz2:en=c,d")
+	want := []string{"de=http://host/de-index.txt.bz2,https://host/de.xml.bz2", "en=c,d"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
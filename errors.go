@@ -0,0 +1,68 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// searchForm lets readers on an error page look for something else
+const searchForm = `<form action="/search">
+<input type="search" name="q">
+<input type="submit" value="Search">
+</form>
+<p><a href="/">Home</a></p>
+`
+
+var errorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Status}}</title>
+<link rel="stylesheet" href="/tinypedia.css">
+</head>
+<body>
+<h1>{{.Status}}</h1>
+<p>{{.Message}}</p>
+` + searchForm + `</body>
+</html>
+`))
+
+// errorFormat picks the representation of an error response for r the way
+// articles are negotiated. Raw wikitext is requested by tools which get
+// plain text.
+func errorFormat(r *http.Request) string {
+	if r.URL.Query().Get("action") == "raw" {
+		return "text"
+	}
+	if format, ok := articleFormat(r); ok {
+		return format
+	}
+	return "html"
+}
+
+// writeError is like http.Error but responds with an HTML page with a search
+// form to browsers and with JSON to API clients
+func writeError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	w.Header().Add("Vary", "Accept")
+	switch errorFormat(r) {
+	case "json":
+		writeJSON(w, status, errorJSON{message})
+		return
+	case "text":
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", htmlContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	err := errorTemplate.Execute(w, struct {
+		Status  string
+		Message string
+	}{http.StatusText(status), message})
+	if err != nil {
+		logError(err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrorNegotiation(t *testing.T) {
+	h := wikiRoute(newTestHandler(t))
+	tests := []struct {
+		target, accept string
+		status         int
+		contentType    string
+		body           string
+	}{
+		{"/wiki/Nothing_here", "text/html", 404, htmlContentType, `<form action="/search">`},
+		{"/wiki/Nothing_here", "application/json", 404, "application/json", `"error":"not found"`},
+		{"/wiki/Nothing_here?action=raw", "application/json", 404, textContentType, "article not found"},
+		{"/wiki/Alan_Turing?format=xml", "", 400, htmlContentType, "format must be html, json or text"},
+		{"/wiki/A%00b", "application/json", 400, "application/json", `"error":`},
+		{"/wiki/Alan_Turing?refs=keep", "text/html;q=0.5, text/plain", 400, textContentType, "refs must be strip or collect"},
+	}
+	for _, tt := range tests {
+		rec := get(h, tt.target, "Accept", tt.accept)
+		if rec.Code != tt.status || !strings.HasPrefix(rec.Header().Get("Content-Type"), tt.contentType) ||
+			!strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("%s with Accept %q: got %d %q %q, want %d %q containing %q", tt.target, tt.accept,
+				rec.Code, rec.Header().Get("Content-Type"), rec.Body.String(), tt.status, tt.contentType, tt.body)
+		}
+		if !strings.Contains(rec.Header().Get("Vary"), "Accept") {
+			t.Errorf("%s with Accept %q: got Vary %q", tt.target, tt.accept, rec.Header().Get("Vary"))
+		}
+		if tt.contentType == "application/json" {
+			var decoded map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+				t.Errorf("%s: invalid JSON: %v", tt.target, err)
+			}
+		}
+	}
+}
+
+func TestWriteErrorHead(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeError(rec, httptest.NewRequest(http.MethodHead, "/wiki/Alan_Turing", nil), "gone", http.StatusGone)
+	if rec.Code != http.StatusGone || rec.Header().Get("Content-Type") != htmlContentType || rec.Body.Len() != 0 {
+		t.Errorf("got %d %q with %d bytes", rec.Code, rec.Header().Get("Content-Type"), rec.Body.Len())
+	}
+}
+
+func TestRemovedContentFile(t *testing.T) {
+	dir := t.TempDir()
+	indexPath, contentPath := filepath.Join(dir, "index.txt.bz2"), filepath.Join(dir, "dump.xml.bz2")
+	copyFile(t, testIndexPath, indexPath)
+	copyFile(t, testContentPath, contentPath)
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	mux := http.NewServeMux()
+	mux.Handle("/wiki/", wikiRoute(h))
+	wikiRoutes(mux, "", h)
+	targets := []string{"/wiki/Alan_Turing", "/wiki/Alan_Turing?action=raw", "/wiki/Alan_Turing?format=json", "/api/article/Alan_Turing", "/text/Alan_Turing"}
+	if err := os.Remove(contentPath); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range targets {
+		if rec := get(mux, target); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "content file unavailable") {
+			t.Errorf("%s without the dump: got %d %q", target, rec.Code, rec.Body.String())
+		}
+	}
+	// Once the file is back the same handler serves it again
+	copyFile(t, testContentPath, contentPath)
+	for _, target := range targets {
+		if rec := get(mux, target); rec.Code != http.StatusOK {
+			t.Errorf("%s with the dump restored: got %d %q", target, rec.Code, rec.Body.String())
+		}
+	}
+}
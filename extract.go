@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var errArticleNotFound = errors.New("article not found")
+
+// wikiPage holds the parts of a <page> element of the dump that we use
+type wikiPage struct {
+	Title         string
+	Id            uint64
+	RevisionId    uint64
+	Timestamp     string
+	Contributor   string
+	ContributorId uint64
+	Text          string
+}
+
+// contextReader fails reads with the error of its context once it is done so
+// decompression stops early for canceled requests
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// validUTF8Reader replaces invalid UTF-8 sequences read from r by U+FFFD
+// since the XML decoder would otherwise give up on the whole stream
+type validUTF8Reader struct {
+	r           io.Reader
+	buf         []byte
+	out         []byte
+	pending     []byte
+	err         error
+	replacement []byte
+}
+
+var replacementChar = []byte(string(utf8.RuneError))
+
+func newValidUTF8Reader(r io.Reader) *validUTF8Reader {
+	return &validUTF8Reader{r: r, buf: make([]byte, 32<<10), replacement: replacementChar}
+}
+
+// newOffsetPreservingUTF8Reader is like newValidUTF8Reader but replaces
+// invalid bytes by ? so offsets into its output are offsets into r
+func newOffsetPreservingUTF8Reader(r io.Reader) *validUTF8Reader {
+	return &validUTF8Reader{r: r, buf: make([]byte, 32<<10), replacement: []byte("?")}
+}
+
+func (v *validUTF8Reader) Read(p []byte) (int, error) {
+	for len(v.out) == 0 {
+		if v.err != nil {
+			return 0, v.err
+		}
+		n := copy(v.buf, v.pending)
+		read, err := v.r.Read(v.buf[n:])
+		n += read
+		v.err = err
+		// A rune cut off at the end of the buffer is completed by the
+		// next read unless there is none
+		keep := 0
+		if err == nil {
+			keep = incompleteRuneLen(v.buf[:n])
+		}
+		v.pending = append(v.pending[:0], v.buf[n-keep:n]...)
+		v.out = v.buf[:n-keep]
+		if !utf8.Valid(v.out) {
+			v.out = replaceInvalidUTF8(v.out, v.replacement)
+		}
+	}
+	n := copy(p, v.out)
+	v.out = v.out[n:]
+	return n, nil
+}
+
+// replaceInvalidUTF8 replaces every byte of b that isn't part of a valid
+// rune by replacement. Unlike bytes.ToValidUTF8 runs of invalid bytes aren't
+// merged so the result doesn't depend on how the input was split into reads.
+func replaceInvalidUTF8(b, replacement []byte) []byte {
+	valid := make([]byte, 0, len(b)+8)
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			valid = append(valid, replacement...)
+		} else {
+			valid = append(valid, b[:size]...)
+		}
+		b = b[size:]
+	}
+	return valid
+}
+
+// incompleteRuneLen returns the length of the start of a multi-byte rune
+// that b ends with
+func incompleteRuneLen(b []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// extractPage finds the page with the id offId.Id in the stream starting at
+// offId.Offset and ending at end, which is -1 if the end isn't known. With a
+// title given the page is matched by title as done by findPage.
+func extractPage(ctx context.Context, dump dumpSource, offId OffsetAndId, title string, end int64) (*wikiPage, error) {
+	defer observeExtraction(time.Now())
+	contentStream, multiStream, err := openStream(ctx, dump, offId.Offset, end)
+	if err != nil {
+		return nil, err
+	}
+	defer multiStream.Close()
+	return findPage(contentStream, offId, title)
+}
+
+// openStream returns the decompressed stream of the dump starting at offset
+// and ending at end, which is -1 if the end isn't known, along with the dump
+// to close afterwards. The dump is opened anew for every stream so concurrent
+// extractions never share a file offset. Opening takes a few microseconds
+// which is negligible next to decompressing the stream, so there is no pool
+// of open handles. The offsets of a compressed single stream dump lie in the
+// decompressed dump, see openDecompressed.
+func openStream(ctx context.Context, dump dumpSource, offset, end int64) (io.Reader, io.Closer, error) {
+	decompress, err := decompressorFor(dump.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	multiStream, err := dump.open(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("content file unavailable: %w", err)
+	}
+	if dump.decompressed {
+		contentStream, err := openDecompressed(contextReader{ctx, io.NewSectionReader(multiStream, 0, math.MaxInt64)}, decompress, offset, end)
+		if err != nil {
+			multiStream.Close()
+			return nil, nil, err
+		}
+		return contentStream, multiStream, nil
+	}
+	length := end - offset
+	if end < 0 {
+		length = math.MaxInt64 - offset
+	}
+	contentStream, err := decompress(contextReader{ctx, io.NewSectionReader(multiStream, offset, length)})
+	if err != nil {
+		multiStream.Close()
+		return nil, nil, fmt.Errorf("opening stream at offset %d: %w", offset, err)
+	}
+	return contentStream, multiStream, nil
+}
+
+// openDecompressed returns the part of the single stream dump between the
+// offsets offset and end of its decompressed content, end being -1 if the part
+// goes up to the end. A single stream can't be decompressed from the middle,
+// so everything before offset is decompressed and thrown away, which takes
+// longer the further into the dump the part starts.
+func openDecompressed(dump io.Reader, decompress decompressor, offset, end int64) (io.Reader, error) {
+	contentStream, err := decompress(dump)
+	if err != nil {
+		return nil, fmt.Errorf("opening the single stream: %w", err)
+	}
+	if _, err := io.CopyN(io.Discard, contentStream, offset); err != nil {
+		return nil, fmt.Errorf("seeking to offset %d of the single stream: %w", offset, err)
+	}
+	if end < 0 {
+		return contentStream, nil
+	}
+	return io.LimitReader(contentStream, end-offset), nil
+}
+
+// findPage decodes the decompressed stream at offId.Offset until it finds the
+// page with the id offId.Id. If title isn't empty the page is matched by its
+// title instead and the id only decides between several pages of the same
+// title. A page with the id but another title is only taken if no page has
+// the title.
+func findPage(contentStream io.Reader, offId OffsetAndId, title string) (*wikiPage, error) {
+	rank := func(page *wikiPage) int {
+		switch {
+		case page.Id == offId.Id && (title == "" || page.Title == title):
+			return 3
+		case title != "" && page.Title == title:
+			return 2
+		case page.Id == offId.Id:
+			return 1
+		}
+		return 0
+	}
+	var found *wikiPage
+	err := scanPages(contentStream, func(page *wikiPage) bool {
+		return rank(page) > 0
+	}, func(page *wikiPage) bool {
+		if found == nil || rank(page) > rank(found) {
+			found = page
+		}
+		return rank(found) < 3
+	})
+	if err != nil {
+		return nil, fmt.Errorf("extracting id %d from stream at offset %d: %w", offId.Id, offId.Offset, err)
+	}
+	if found == nil {
+		return nil, errArticleNotFound
+	}
+	return found, nil
+}
+
+// eachPageInStream calls fn with the title, id and wikitext of every page of
+// the stream starting at offset in the order of the dump, e.g. to build
+// custom indexes or exports. It stops at the start of the next stream in the
+// index or once fn fails and returns fn's error or that of decoding. The id
+// is passed in decimal as it appears in the dump and the index.
+func (h *TinyWikiHandler) eachPageInStream(offset int64, fn func(title, id, text string) error) error {
+	data := h.data.Load()
+	contentStream, multiStream, err := openStream(context.Background(), data.dump, offset, streamEnd(data.streams, offset))
+	if err != nil {
+		return err
+	}
+	defer multiStream.Close()
+	var fnErr error
+	err = scanPages(contentStream, func(page *wikiPage) bool {
+		return true
+	}, func(page *wikiPage) bool {
+		fnErr = fn(page.Title, strconv.FormatUint(page.Id, 10), page.Text)
+		return fnErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// scanPages decodes the pages of contentStream and calls fn for each page
+// that wanted accepts, until fn returns false or the stream ends. wanted is
+// called as soon as the page's title and id are known so the rest of
+// unwanted pages is skipped cheaply. Elements are identified by their path
+// below <page> so the page id is never confused with the revision or
+// contributor ids, no matter which elements like <redirect> come before them.
+func scanPages(contentStream io.Reader, wanted, fn func(page *wikiPage) bool) error {
+	return decodePages(bufio.NewReader(newValidUTF8Reader(contentStream)), wanted, fn, nil)
+}
+
+// decodePages is scanPages on a buffered stream. If onText is given, it is
+// called at the start of the <text> of the first wanted page instead of
+// decoding it. raw is then positioned right after the start tag and decoding
+// ends with the result of onText.
+func decodePages(raw *bufio.Reader, wanted, fn func(page *wikiPage) bool, onText func(page *wikiPage, text xml.StartElement, raw *bufio.Reader) error) error {
+	// As a ByteReader raw is read by the decoder without buffering of its
+	// own, so raw continues where the decoder stopped
+	dexml := xml.NewDecoder(raw)
+	// A stray & in a text or an unclosed tag shouldn't cost the whole
+	// stream. Non-strict decoding leaves unknown entities as they are and
+	// closes elements as needed.
+	dexml.Strict = false
+	// The dumps are UTF-8 whatever encoding they declare and invalid bytes
+	// have already been replaced
+	dexml.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var (
+		inPage, matched bool
+		path            []string
+		tempData        bytes.Buffer
+		page            *wikiPage
+	)
+	// boundary is where the last token ended
+	var boundary int64
+	for {
+		tok, err := dexml.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && !inPage && isDumpEdge(err, dexml.InputOffset() == boundary) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		boundary = dexml.InputOffset()
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if tok.Name.Local == "page" {
+				inPage, matched = true, false
+				path = path[:0]
+				page = &wikiPage{}
+			} else if inPage {
+				path = append(path, tok.Name.Local)
+				if len(path) == 1 && tok.Name.Local == "revision" {
+					// Dumps with history hold several revisions
+					// of which the last one wins, so nothing of
+					// an earlier revision may leak into it
+					page.RevisionId, page.Timestamp, page.Text = 0, "", ""
+					page.Contributor, page.ContributorId = "", 0
+				}
+				if matched && onText != nil && strings.Join(path, "/") == "revision/text" {
+					return onText(page, tok, raw)
+				}
+			}
+			tempData.Reset()
+		case xml.EndElement:
+			if tok.Name.Local == "page" {
+				if matched && !fn(page) {
+					return nil
+				}
+				inPage = false
+				continue
+			}
+			if !inPage || len(path) == 0 {
+				continue
+			}
+			value := tempData.String()
+			tempData.Reset()
+			switch strings.Join(path, "/") {
+			case "title":
+				page.Title = value
+			case "id":
+				page.Id, err = strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					logError(err)
+				}
+				matched = err == nil && wanted(page)
+			case "revision/id":
+				page.RevisionId, _ = strconv.ParseUint(value, 10, 64)
+			case "revision/timestamp":
+				page.Timestamp = value
+			case "revision/contributor/username", "revision/contributor/ip":
+				page.Contributor = value
+			case "revision/contributor/id":
+				page.ContributorId, _ = strconv.ParseUint(value, 10, 64)
+			case "revision/text":
+				page.Text = strings.TrimPrefix(value, "\uFEFF")
+			}
+			path = path[:len(path)-1]
+		case xml.CharData:
+			// Until the page is known to be wanted only the direct
+			// children of <page> are of interest
+			if inPage && (matched || len(path) == 1) {
+				tempData.Write(tok)
+			}
+		}
+	}
+}
+
+// isDumpEdge reports whether the decoding error err only stems from a stream
+// being decoded on its own rather than as part of the whole dump: the first
+// stream ends with <mediawiki> still open and the last one closes it without
+// having opened it. atBoundary tells whether decoding stopped between two
+// tokens, a stream cut within a tag is broken rather than at its end.
+func isDumpEdge(err error, atBoundary bool) bool {
+	var syntaxErr *xml.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return false
+	}
+	return syntaxErr.Msg == "unexpected end element </mediawiki>" || (syntaxErr.Msg == "unexpected EOF" && atBoundary)
+}
+
+// printArticle writes the raw markup of the article titled rawTitle to out
+func (h *TinyWikiHandler) printArticle(out io.Writer, rawTitle string) error {
+	_, _, content, err := h.lookup(context.Background(), rawTitle)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, content)
+	return err
+}
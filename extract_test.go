@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
+)
+
+// pageShapes holds pages of the different shapes found in dumps
+const pageShapes = `<mediawiki>
+  <page>
+    <title>NYC</title>
+    <ns>0</ns>
+    <id>11</id>
+    <redirect title="New York City" />
+    <revision>
+      <id>1011</id>
+      <timestamp>2018-03-01T12:00:00Z</timestamp>
+      <contributor><username>Bob</username><id>12</id></contributor>
+      <text xml:space="preserve">#REDIRECT [[New York City]]</text>
+    </revision>
+  </page>
+  <page>
+    <title>New York City</title>
+    <ns>0</ns>
+    <id>12</id>
+    <revision>
+      <id>1012</id>
+      <timestamp>2018-03-02T12:00:00Z</timestamp>
+      <contributor><username>Alice</username><id>99</id></contributor>
+      <text xml:space="preserve">The most populous city.</text>
+    </revision>
+  </page>
+  <page>
+    <title>History</title>
+    <ns>0</ns>
+    <id>13</id>
+    <revision>
+      <id>1013</id>
+      <timestamp>2018-03-03T12:00:00Z</timestamp>
+      <contributor><username>Bob</username><id>7</id></contributor>
+      <text xml:space="preserve">First version</text>
+    </revision>
+    <revision>
+      <id>1014</id>
+      <timestamp>2018-03-04T12:00:00Z</timestamp>
+      <contributor deleted="deleted" />
+      <text xml:space="preserve">Second version</text>
+    </revision>
+  </page>
+  <page>
+    <title>New York City</title>
+    <ns>0</ns>
+    <id>14</id>
+    <revision>
+      <id>1015</id>
+      <text xml:space="preserve">A page of the same title</text>
+    </revision>
+  </page>
+</mediawiki>
+`
+
+func TestFindPage(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    uint64
+		title string
+		want  wikiPage
+	}{
+		{"redirect page", 11, "", wikiPage{"NYC", 11, 1011, "2018-03-01T12:00:00Z", "Bob", 12, "#REDIRECT [[New York City]]"}},
+		{"contributor id equal to a wanted page id", 12, "", wikiPage{"New York City", 12, 1012, "2018-03-02T12:00:00Z", "Alice", 99, "The most populous city."}},
+		{"multiple revisions", 13, "", wikiPage{"History", 13, 1014, "2018-03-04T12:00:00Z", "", 0, "Second version"}},
+		{"title decides", 99, "History", wikiPage{"History", 13, 1014, "2018-03-04T12:00:00Z", "", 0, "Second version"}},
+		{"id decides between equal titles", 14, "New York City", wikiPage{"New York City", 14, 1015, "", "", 0, "A page of the same title"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := findPage(strings.NewReader(pageShapes), OffsetAndId{0, tt.id}, tt.title)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *page != tt.want {
+				t.Errorf("got %+v, want %+v", *page, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindPageMissing(t *testing.T) {
+	// 99 is only a contributor id
+	if _, err := findPage(strings.NewReader(pageShapes), OffsetAndId{0, 99}, ""); err != errArticleNotFound {
+		t.Errorf("got %v, want %v", err, errArticleNotFound)
+	}
+}
+
+func TestFindPageCorruptStream(t *testing.T) {
+	const page = "<page><title>A</title><ns>0</ns><id>1</id><revision><id>2</id><text>a</text></revision></page>\n"
+	tests := []struct {
+		name, stream string
+		corrupt      bool
+	}{
+		{"first stream", "<mediawiki>\n<siteinfo></siteinfo>\n" + page, false},
+		{"last stream", page + "</mediawiki>\n", false},
+		{"stray end element", page + "</bogus>\n" + page, true},
+		{"cut within a tag", page + "<pa", true},
+		{"cut within a page", page + "<page><title>B", true},
+	}
+	for _, tt := range tests {
+		_, err := findPage(strings.NewReader(tt.stream), OffsetAndId{0, 99}, "")
+		if tt.corrupt && (err == nil || err == errArticleNotFound) {
+			t.Errorf("%s: got %v, want a decoding error", tt.name, err)
+		}
+		if !tt.corrupt && err != errArticleNotFound {
+			t.Errorf("%s: got %v, want %v", tt.name, err, errArticleNotFound)
+		}
+	}
+}
+
+// TestExtractPageConcurrently extracts from the same dump in 16 goroutines,
+// run it with -race to check that extractions share no state
+func TestExtractPageConcurrently(t *testing.T) {
+	h := newTestHandler(t)
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 1; i <= 100; i += 20 {
+				title := fmt.Sprintf("Sample %03d", (i+g)%100+1)
+				offId, _ := h.index().Lookup(title)
+				page, err := extractPage(context.Background(), dumpSource{path: testContentPath}, offId, "", -1)
+				if err != nil || page.Title != title {
+					t.Errorf("extracting %s: got %v, %v", title, page, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestPrintArticle(t *testing.T) {
+	h := newTestHandler(t)
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Alan Turing", "'''Alan Mathison Turing''' was an English"},
+		{"alan_Turing", "'''Alan Mathison Turing''' was an English"},
+		{"NYC", "#REDIRECT [[New York City]]"},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		if err := h.printArticle(&out, tt.title); err != nil {
+			t.Fatalf("%s: %v", tt.title, err)
+		}
+		if !strings.Contains(out.String(), tt.want) || !strings.HasSuffix(out.String(), "\n") {
+			t.Errorf("%s: got %.60q, want it to contain %q and end in a newline", tt.title, out.String(), tt.want)
+		}
+	}
+	var out strings.Builder
+	if err := h.printArticle(&out, "Missing article"); err != errArticleNotFound || out.Len() != 0 {
+		t.Errorf("missing article: got %v after writing %q, want %v", err, out.String(), errArticleNotFound)
+	}
+}
+
+// BenchmarkOpenDump measures opening and closing the dump which every
+// extraction does, to compare with BenchmarkExtractArticle
+func BenchmarkOpenDump(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		f, err := openContent(testContentPath)
+		if err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+	}
+}
+
+// BenchmarkExtractArticle extracts the first and the last page of a stream
+// of a hundred pages, the difference is the cost of scanning past the others
+func BenchmarkExtractArticle(b *testing.B) {
+	h := newTestHandler(b)
+	for _, bench := range []struct{ name, title string }{
+		{"early", "Sample 001"},
+		{"late", "Sample 100"},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			offId, _ := h.index().Lookup(bench.title)
+			end := streamEnd(h.data.Load().streams, offId.Offset)
+			for i := 0; i < b.N; i++ {
+				if _, err := extractPage(context.Background(), dumpSource{path: testContentPath}, offId, "", end); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestExtractPageStopsAtStreamEnd looks for an id missing from the first
+// stream with the following one damaged, which fails if it is read at all
+func TestExtractPageStopsAtStreamEnd(t *testing.T) {
+	streams := newTestHandler(t).data.Load().streams
+	dump, err := os.ReadFile(testContentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := streams[1]; i < streams[2]; i++ {
+		dump[i] = 0
+	}
+	damaged := filepath.Join(t.TempDir(), "damaged.xml.bz2")
+	if err := os.WriteFile(damaged, dump, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := OffsetAndId{streams[0], 9999}
+	if _, err := extractPage(context.Background(), dumpSource{path: damaged}, missing, "", streams[1]); err != errArticleNotFound {
+		t.Errorf("bounded by the next stream: got %v, want %v", err, errArticleNotFound)
+	}
+	if _, err := extractPage(context.Background(), dumpSource{path: damaged}, missing, "", -1); err == nil || err == errArticleNotFound {
+		t.Errorf("unbounded: got %v, want the damaged stream to fail", err)
+	}
+}
+
+// cancelAfter cancels a context once n bytes have been read through it
+type cancelAfter struct {
+	r      io.Reader
+	n      int
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if c.n -= n; c.n <= 0 {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestExtractionCanceledMidStream(t *testing.T) {
+	// An uncompressed dump, so every read reaches the file
+	var dump strings.Builder
+	dump.WriteString("<mediawiki>\n")
+	for i := 1; i <= 2000; i++ {
+		fmt.Fprintf(&dump, "<page><title>Page %d</title><ns>0</ns><id>%d</id><revision><id>%d</id><text>%s</text></revision></page>\n",
+			i, i, i, strings.Repeat("text ", 100))
+	}
+	dump.WriteString("</mediawiki>\n")
+	path := filepath.Join(t.TempDir(), "dump.xml")
+	if err := os.WriteFile(path, []byte(dump.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	contentStream, multiStream, err := openStream(ctx, dumpSource{path: path}, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer multiStream.Close()
+	start := time.Now()
+	_, err = findPage(&cancelAfter{contentStream, 64 << 10, cancel}, OffsetAndId{0, 9999}, "")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %v", elapsed)
+	}
+}
+
+func TestExtractionTimeoutStatus(t *testing.T) {
+	h := newTestHandler(t)
+	h.extractTimeout = time.Nanosecond
+	if rec := get(wikiRoute(h), "/wiki/Sample_100"); rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("timed out: got %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+	h.extractTimeout = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodGet, "/wiki/Sample_100", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	wikiRoute(h).ServeHTTP(rec, r)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("canceled: got %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestEachPageInStream(t *testing.T) {
+	h := newTestHandler(t)
+	streams := h.data.Load().streams
+	seen := make(map[string]string)
+	err := h.eachPageInStream(streams[2], func(title, id, text string) error {
+		if _, ok := seen[id]; ok {
+			t.Errorf("page %s %q visited twice", id, title)
+		}
+		seen[id] = title
+		if !strings.HasPrefix(text, "Sample page number") {
+			t.Errorf("page %s %q: got text %.40q", id, title, text)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 100 {
+		t.Errorf("visited %d pages, want 100", len(seen))
+	}
+	for i := 1; i <= 100; i++ {
+		if id, want := strconv.Itoa(100+i), fmt.Sprintf("Sample %03d", i); seen[id] != want {
+			t.Errorf("page %s: got %q, want %q", id, seen[id], want)
+		}
+	}
+
+	// The first stream ends where the one of the talk pages starts
+	var titles []string
+	if err := h.eachPageInStream(streams[0], func(title, id, text string) error {
+		titles = append(titles, title)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(titles) != 4 || titles[0] != "Alan Turing" || slices.Contains(titles, "Talk:Alan Turing") {
+		t.Errorf("first stream: got %q", titles)
+	}
+
+	errStop := errors.New("stop")
+	visited := 0
+	err = h.eachPageInStream(streams[2], func(title, id, text string) error {
+		if visited++; visited == 3 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || visited != 3 {
+		t.Errorf("failing fn: got %v after %d pages, want %v after 3", err, visited, errStop)
+	}
+	if err := h.eachPageInStream(streams[0]+1, func(title, id, text string) error { return nil }); err == nil {
+		t.Error("no error for an offset inside a stream")
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	// Byte at a time reads cut the runes of the input apart
+	const input = "caf\xc3\xa9 \xff\xfe \xe2\x82 \xc3"
+	valid, err := io.ReadAll(newValidUTF8Reader(iotest.OneByteReader(strings.NewReader(input))))
+	if err != nil || string(valid) != "café �� �� �" {
+		t.Errorf("got %q, %v", valid, err)
+	}
+
+	// xml.EscapeText would replace the invalid bytes already
+	indexPath, contentPath := writeRawDump(t, "<mediawiki>\n<page>\n<title>Broken</title>\n<ns>0</ns>\n<id>1</id>\n<revision>\n<id>1001</id>\n"+
+		"<text xml:space=\"preserve\">\uFEFFStray \xff\xc0 bytes in caf\xc3\xa9 \xe2\x82</text>\n</revision>\n</page>\n</mediawiki>\n", "Broken", "1")
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	mux := http.NewServeMux()
+	mux.Handle("/wiki/", wikiRoute(h))
+	wikiRoutes(mux, "", h)
+	const want = "Stray �� bytes in café ��"
+	if rec := get(mux, "/wiki/Broken?action=raw"); rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("raw: got %d %q, want %q", rec.Code, rec.Body.String(), want)
+	}
+	for _, target := range []string{"/wiki/Broken?format=json", "/api/article/Broken"} {
+		rec := get(mux, target)
+		var article articleJSON
+		if !utf8.Valid(rec.Body.Bytes()) || json.Unmarshal(rec.Body.Bytes(), &article) != nil || article.Content != want {
+			t.Errorf("%s: got %d %q", target, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestLenientDecoding(t *testing.T) {
+	indexPath, contentPath := writeRawDump(t, `<?xml version="1.0" encoding="ISO-8859-1"?>
+<mediawiki>
+<page>
+<title>Loose</title>
+<ns>0</ns>
+<id>1</id>
+<revision>
+<id>1001</id>
+<text xml:space="preserve">AT&T &amp; Bell Labs&nbsp;in &#77;urray Hill, caf`+"\xc3\xa9"+`</text>
+</revision>
+</page>
+</mediawiki>
+`, "Loose", "1")
+	rec := get(wikiRoute(loadTestWiki(t, indexPath, contentPath, defaultLinkBase)), "/wiki/Loose?action=raw")
+	if want := "AT&T & Bell Labs&nbsp;in Murray Hill, café"; rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("got %d %q, want %q", rec.Code, rec.Body.String(), want)
+	}
+}
+
+func TestMatchBy(t *testing.T) {
+	page := func(title, id, revision, contributor string) string {
+		return "<page>\n<title>" + title + "</title>\n<ns>0</ns>\n<id>" + id + "</id>\n<revision>\n<id>" + revision + "</id>\n" +
+			"<contributor>\n<username>Bob</username>\n<id>" + contributor + "</id>\n</contributor>\n" +
+			"<text xml:space=\"preserve\">" + title + " text</text>\n</revision>\n</page>\n"
+	}
+	// The revision and contributor ids of Alpha and Beta are each other's
+	// page ids, Gamma shares the page id of Alpha and the index has a stale
+	// id for Delta
+	indexPath, contentPath := writeRawDump(t, "<mediawiki>\n"+page("Alpha", "7", "8", "9")+page("Beta", "8", "7", "7")+
+		page("Gamma", "7", "12", "8")+page("Delta", "10", "13", "8")+"</mediawiki>\n",
+		"Alpha", "7", "Beta", "8", "Gamma", "7", "Delta", "11")
+	defer func(by string) { matchBy = by }(matchBy)
+	tests := map[string]map[string]string{
+		"id":    {"Alpha": "Alpha text", "Beta": "Beta text", "Gamma": "Alpha text", "Delta": ""},
+		"title": {"Alpha": "Alpha text", "Beta": "Beta text", "Gamma": "Gamma text", "Delta": "Delta text"},
+	}
+	for by, want := range tests {
+		matchBy = by
+		h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+		// With the article cache Gamma mustn't get the page cached for
+		// the id of Alpha
+		for _, articles := range []int{0, 10} {
+			h.data.Store(newWikiData(h.index(), h.data.Load().dump, articles, 0))
+			for _, title := range []string{"Alpha", "Beta", "Gamma", "Delta"} {
+				rec := get(wikiRoute(h), "/wiki/"+title+"?action=raw")
+				if want[title] == "" && rec.Code != http.StatusNotFound || want[title] != "" && rec.Body.String() != want[title] {
+					t.Errorf("-matchby %s with %d cached articles, %s: got %d %q, want %q", by, articles, title, rec.Code, rec.Body.String(), want[title])
+				}
+			}
+		}
+	}
+	matchBy = "name"
+	namespaces, _ := parseNamespaces("0")
+	if _, err := loadWiki(indexPath, contentPath, "", defaultLinkBase, namespaces); err == nil {
+		t.Error("unknown -matchby accepted")
+	}
+}
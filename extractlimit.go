@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errTooBusy is returned for extractions that found no free slot in time
+var errTooBusy = errors.New("too many concurrent extractions")
+
+// maxExtractionWait is how long an extraction queues for a slot before it
+// gives up with errTooBusy
+var maxExtractionWait = 5 * time.Second
+
+// extractionSlots limits the extractions running at once across all wikis
+// to its capacity, set by -maxconcurrent. Without it they are unlimited.
+var extractionSlots chan struct{}
+
+// acquireExtraction takes one of the extractionSlots, waiting at most
+// maxExtractionWait and as long as ctx isn't done. The returned function
+// gives the slot back.
+func acquireExtraction(ctx context.Context) (release func(), err error) {
+	if extractionSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case extractionSlots <- struct{}{}:
+		return func() { <-extractionSlots }, nil
+	default:
+	}
+	timer := time.NewTimer(maxExtractionWait)
+	defer timer.Stop()
+	select {
+	case extractionSlots <- struct{}{}:
+		return func() { <-extractionSlots }, nil
+	case <-timer.C:
+		metrics.tooBusy.Add(1)
+		return nil, errTooBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractionLimit(t *testing.T) {
+	routes := wikiRoute(newTestHandler(t))
+	extractionSlots = make(chan struct{}, 1)
+	wait := maxExtractionWait
+	defer func() { extractionSlots, maxExtractionWait = nil, wait }()
+	maxExtractionWait = 50 * time.Millisecond
+
+	// With the only slot taken requests give up after the wait
+	extractionSlots <- struct{}{}
+	tooBusy := metrics.tooBusy.Load()
+	tests := []struct {
+		target, accept, contentType string
+	}{
+		{"/wiki/Alan_Turing", "application/json", "application/json"},
+		{"/wiki/Alan_Turing", "text/html", htmlContentType},
+		{"/wiki/Alan_Turing?action=raw&stream=1", "", textContentType},
+	}
+	for _, tt := range tests {
+		rec := get(routes, tt.target, "Accept", tt.accept)
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Content-Type") != tt.contentType ||
+			!strings.Contains(rec.Body.String(), "too many concurrent extractions") {
+			t.Errorf("%s with Accept %q: got %d %q %q", tt.target, tt.accept, rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+		}
+		if !strings.Contains(rec.Header().Get("Vary"), "Accept") {
+			t.Errorf("%s: got Vary %q", tt.target, rec.Header().Get("Vary"))
+		}
+	}
+	if got := metrics.tooBusy.Load() - tooBusy; got != uint64(len(tests)) {
+		t.Errorf("counted %d requests as too busy, want %d", got, len(tests))
+	}
+
+	// A request waiting for the slot gets it once it is given back
+	maxExtractionWait = 10 * time.Second
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		<-extractionSlots
+	}()
+	for _, target := range []string{"/wiki/Alan_Turing?action=raw", "/wiki/Alan_Turing?action=raw&stream=1"} {
+		if rec := get(routes, target); rec.Code != 200 {
+			t.Errorf("%s after waiting: got %d %q", target, rec.Code, rec.Body.String())
+		}
+	}
+	if len(extractionSlots) != 0 {
+		t.Error("slot not given back after the extraction")
+	}
+}
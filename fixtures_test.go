@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fixtures in testdata are tiny multistream dumps. multistream.xml.bz2
+// has a stream of four pages starting with Alan Turing, one of four starting
+// with the talk page Talk:Alan Turing and one of a hundred pages Sample 001
+// to Sample 100. dewiki.xml.bz2 holds Berlin and Alan Turing, gzip.xml.gz
+// the first two streams of multistream.xml.bz2 gzip compressed, split/ the
+// same two streams as files of their own and single.xml.bz2 the same pages
+// as a single stream without index.
+const (
+	testIndexPath   = "testdata/multistream-index.txt.bz2"
+	testContentPath = "testdata/multistream.xml.bz2"
+)
+
+// newTestHandler loads the multistream fixture the way the server does with
+// the default flags but without caches, so every request extracts its page
+func newTestHandler(t testing.TB) *TinyWikiHandler {
+	t.Helper()
+	return loadTestWiki(t, testIndexPath, testContentPath, defaultLinkBase)
+}
+
+func loadTestWiki(t testing.TB, indexPath, contentPath, linkBase string) *TinyWikiHandler {
+	t.Helper()
+	namespaces, err := parseNamespaces("0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := loadWiki(indexPath, contentPath, "", linkBase, namespaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.data.Store(newWikiData(h.index(), h.data.Load().dump, 0, 0))
+	return h
+}
+
+// get sends a GET request for target to handler and returns the recorded
+// response. header lists names and values of request headers.
+func get(handler http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// wikiRoute mounts h below /wiki/ like the server does
+func wikiRoute(h *TinyWikiHandler) http.Handler {
+	return http.StripPrefix("/wiki/", h)
+}
+
+// writeGzipDump writes a gzip compressed multistream dump with a stream of
+// its own for each of the pages given as title and text pairs, along with its
+// index. The pages get the ids 1, 2 and so on. It returns the paths of the
+// index and the dump.
+func writeGzipDump(t testing.TB, pages ...string) (indexPath, contentPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	indexPath, contentPath = filepath.Join(dir, "index.txt"), filepath.Join(dir, "dump.xml.gz")
+	dump, err := os.Create(contentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dump.Close()
+	var index strings.Builder
+	writeStream := func(xmlText string) {
+		zw := gzip.NewWriter(dump)
+		if _, err := zw.Write([]byte(xmlText)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	escape := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	writeStream("<mediawiki>\n")
+	for i := 0; i+1 < len(pages); i += 2 {
+		offset, err := dump.Seek(0, io.SeekCurrent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := i/2 + 1
+		fmt.Fprintf(&index, "%d:%d:%s\n", offset, id, pages[i])
+		writeStream(fmt.Sprintf("<page>\n<title>%s</title>\n<ns>0</ns>\n<id>%d</id>\n<revision>\n<id>%d</id>\n<text xml:space=\"preserve\">%s</text>\n</revision>\n</page>\n",
+			escape(pages[i]), id, 1000+id, escape(pages[i+1])))
+	}
+	writeStream("</mediawiki>\n")
+	if err := os.WriteFile(indexPath, []byte(index.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return indexPath, contentPath
+}
+
+// writeRawDump writes xmlText as it is as a gzip compressed dump of a single
+// stream, along with the index listing the titles with the ids given as
+// title and id pairs at offset 0. It returns the paths of the index and the
+// dump.
+func writeRawDump(t testing.TB, xmlText string, titlesAndIds ...string) (indexPath, contentPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	indexPath, contentPath = filepath.Join(dir, "index.txt"), filepath.Join(dir, "dump.xml.gz")
+	var dump bytes.Buffer
+	zw := gzip.NewWriter(&dump)
+	if _, err := io.WriteString(zw, xmlText); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(contentPath, dump.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var index strings.Builder
+	for i := 0; i+1 < len(titlesAndIds); i += 2 {
+		fmt.Fprintf(&index, "0:%s:%s\n", titlesAndIds[i+1], titlesAndIds[i])
+	}
+	if err := os.WriteFile(indexPath, []byte(index.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return indexPath, contentPath
+}
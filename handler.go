@@ -0,0 +1,675 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+var errRedirectLoop = errors.New("too many redirects")
+
+// maxRedirects bounds the length of redirect chains that are followed
+const maxRedirects = 5
+
+var (
+	redirectRegexp       = regexp.MustCompile(`(?i)^\s*#REDIRECT\s*:?\s*\[\[([^\]|#]*)`)
+	disambiguationRegexp = regexp.MustCompile(`(?i)\{\{\s*(disambiguation|disambig|disamb|dab|hndis|geodis|[a-z ]+ disambiguation)\s*[|}]`)
+)
+
+// TinyWikiHandler serves articles from a multistream dump. The data read
+// from the index is never modified but only replaced as a whole on reload and
+// every extraction opens its own handle to the content file, so a single
+// handler is safe for concurrent requests.
+type TinyWikiHandler struct {
+	data            atomic.Pointer[wikiData]
+	contentFilePath string
+	linkBase        string
+	search          atomic.Pointer[searchIndex]
+	extractTimeout  time.Duration
+	renderer        Renderer
+	maxBytes        int
+	matchByTitle    bool
+	backlinks       atomic.Pointer[backlinkIndex]
+	searchBuild     backgroundBuild
+	backlinksBuild  backgroundBuild
+	aliases         atomic.Pointer[map[string]string]
+
+	// Where the index is reloaded from
+	indexPath, cachePath string
+	namespaces           namespaceSet
+	reloadMu             sync.Mutex
+
+	randomMu sync.Mutex
+	random   *rand.Rand
+}
+
+// NewTinyWikiHandler creates a handler for the dump opened by dump. Its
+// articles are expected to be served below linkBase which is used for links
+// between them.
+func NewTinyWikiHandler(index titleIndex, dump dumpSource, linkBase string, cacheSize int, chunkCacheBytes int64) *TinyWikiHandler {
+	return newTinyWikiHandler(newWikiData(index, dump, cacheSize, chunkCacheBytes), linkBase)
+}
+
+// newTinyWikiHandler creates a handler serving the already loaded data
+func newTinyWikiHandler(data *wikiData, linkBase string) *TinyWikiHandler {
+	h := &TinyWikiHandler{
+		contentFilePath: data.dump.path,
+		linkBase:        linkBase,
+		renderer:        HTMLRenderer{linkBase},
+		random:          rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	h.data.Store(data)
+	return h
+}
+
+// wikiData holds the index of a dump along with everything derived from it.
+// Since cached pages are only valid for the index they were looked up in,
+// the caches are part of it as well.
+type wikiData struct {
+	index    titleIndex
+	dump     dumpSource
+	streams  []int64
+	cache    *articleCache
+	chunks   *chunkCache
+	resolved *lruCache[string, titleResolution]
+	modTime  time.Time
+
+	// version identifies this load of the index in the ETags of responses
+	// derived from it, it changes on every reload
+	version string
+
+	// lowerTitles are the titles of the index in lowercase,
+	// computed on first use by lowercaseTitles
+	lowerOnce   sync.Once
+	lowerTitles []string
+
+	// byId maps page ids back to titles, computed on first use by titleById
+	byIdOnce sync.Once
+	byId     map[uint64]string
+}
+
+// newWikiData derives everything needed to serve dump from its index. The
+// dump's modification time stays zero if it can't be read.
+func newWikiData(index titleIndex, dump dumpSource, cacheSize int, chunkCacheBytes int64) *wikiData {
+	data := &wikiData{
+		index:    index,
+		dump:     dump,
+		streams:  streamOffsets(index),
+		cache:    newArticleCache(cacheSize),
+		chunks:   newChunkCache(chunkCacheBytes),
+		resolved: newLRUCache[string, titleResolution](resolutionCacheSize),
+		version:  indexVersion(index),
+	}
+	if info, err := dump.stat(); err == nil {
+		data.modTime = info.ModTime()
+	} else {
+		logError(err)
+	}
+	return data
+}
+
+// indexVersion hashes the size of index and the time it was loaded
+func indexVersion(index titleIndex) string {
+	hash := fnv.New64a()
+	fmt.Fprint(hash, index.Len(), time.Now().UnixNano())
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// resolutionCacheSize is the number of titles whose lookups are cached
+const resolutionCacheSize = 10000
+
+// titleResolution is the result of findTitle for a raw title
+type titleResolution struct {
+	title       string
+	offsetAndId OffsetAndId
+	ok          bool
+}
+
+// findTitle is findTitle on the data's index. Results for recently requested
+// titles, missing ones included, are cached and go away with the data on
+// reload. Titles no article can have are looked up without the cache, so
+// requests for arbitrary paths can't fill it with long junk keys.
+func (data *wikiData) findTitle(rawTitle string) (string, OffsetAndId, bool) {
+	key := strings.Replace(rawTitle, "_", " ", -1)
+	if validateTitle(key) != nil {
+		return findTitle(data.index, rawTitle)
+	}
+	if res, ok := data.resolved.get(key); ok {
+		return res.title, res.offsetAndId, res.ok
+	}
+	title, offsetAndId, ok := findTitle(data.index, rawTitle)
+	data.resolved.add(key, titleResolution{title, offsetAndId, ok})
+	return title, offsetAndId, ok
+}
+
+// lowercaseTitles returns the titles of the index lowercased in the same
+// order
+func (data *wikiData) lowercaseTitles() []string {
+	data.lowerOnce.Do(func() {
+		data.lowerTitles = make([]string, data.index.Len())
+		for i := range data.lowerTitles {
+			data.lowerTitles[i] = strings.ToLower(data.index.Title(i))
+		}
+	})
+	return data.lowerTitles
+}
+
+// titleById returns the title of the page with the given id. The map from
+// ids to titles is only built once the first id is looked up, so loading
+// an index doesn't pay for it.
+func (data *wikiData) titleById(id uint64) (string, bool) {
+	data.byIdOnce.Do(func() {
+		data.byId = titlesById(data.index)
+	})
+	title, ok := data.byId[id]
+	return title, ok
+}
+
+// index returns the current index of the handler
+func (h *TinyWikiHandler) index() titleIndex {
+	return h.data.Load().index
+}
+
+// lookupPage finds rawTitle with findTitle and extracts its page. Both a title
+// missing from the index and an id missing from its stream are reported as
+// errArticleNotFound.
+func (h *TinyWikiHandler) lookupPage(ctx context.Context, rawTitle string) (title string, offsetAndId OffsetAndId, page *wikiPage, err error) {
+	data := h.data.Load()
+	title, offsetAndId, ok := data.findTitle(rawTitle)
+	if !ok {
+		logDebug("Couldn't find id for", title)
+		return title, offsetAndId, nil, errArticleNotFound
+	}
+	// Matching by title tolerates ids shared by several titles, so a cached
+	// page of the id may belong to another one
+	if page, ok := data.cache.get(offsetAndId.Id); ok && (!h.matchByTitle || page.Title == title) {
+		metrics.cacheHits.Add(1)
+		return title, offsetAndId, page, nil
+	}
+	metrics.cacheMisses.Add(1)
+	page, err = h.extract(ctx, data, title, offsetAndId)
+	if err == errArticleNotFound {
+		logDebug("Couldn't find article", offsetAndId.Id, "at offset", offsetAndId.Offset)
+	}
+	if err == nil {
+		data.cache.add(offsetAndId.Id, page)
+	}
+	return title, offsetAndId, page, err
+}
+
+// extract reads the page at offsetAndId from the dump, decompressing its
+// stream only if it isn't in the chunk cache yet. Decompression stops at the
+// end of the stream or when ctx is done, at the latest after the handler's
+// extractTimeout. With matchByTitle the page is found by title rather than
+// by id.
+func (h *TinyWikiHandler) extract(ctx context.Context, data *wikiData, title string, offsetAndId OffsetAndId) (*wikiPage, error) {
+	if h.extractTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.extractTimeout)
+		defer cancel()
+	}
+	if !h.matchByTitle {
+		title = ""
+	}
+	end := streamEnd(data.streams, offsetAndId.Offset)
+	if !data.chunks.enabled() {
+		release, err := acquireExtraction(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return extractPage(ctx, data.dump, offsetAndId, title, end)
+	}
+	defer observeExtraction(time.Now())
+	chunk, ok := data.chunks.get(offsetAndId.Offset)
+	if ok {
+		metrics.chunkCacheHits.Add(1)
+	} else {
+		release, err := acquireExtraction(ctx)
+		if err != nil {
+			return nil, err
+		}
+		chunk, err = readChunk(ctx, data.dump, offsetAndId.Offset, end)
+		release()
+		if err != nil {
+			return nil, err
+		}
+		data.chunks.add(offsetAndId.Offset, chunk)
+	}
+	return findPage(bytes.NewReader(chunk), offsetAndId, title)
+}
+
+// extractionErrorStatus returns the status and message for a failed
+// extraction, telling timeouts, a lack of free -maxconcurrent slots and a
+// content file that can't be opened or read, e.g. because it was deleted or
+// its volume unmounted, apart from other failures
+func extractionErrorStatus(err error) (int, string) {
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "extraction timed out"
+	case err == errTooBusy:
+		return http.StatusServiceUnavailable, "too many concurrent extractions"
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, "extraction canceled"
+	case errors.As(err, &pathErr):
+		return http.StatusServiceUnavailable, "content file unavailable"
+	}
+	return http.StatusInternalServerError, "failed to extract article"
+}
+
+// lookup is like lookupPage but only returns the article's markup
+func (h *TinyWikiHandler) lookup(ctx context.Context, rawTitle string) (title string, offsetAndId OffsetAndId, content string, err error) {
+	title, offsetAndId, page, err := h.lookupPage(ctx, rawTitle)
+	if err != nil {
+		return title, offsetAndId, "", err
+	}
+	return title, offsetAndId, page.Text, nil
+}
+
+// redirectTarget returns the title a #REDIRECT page points to.
+func redirectTarget(content string) (string, bool) {
+	m := redirectRegexp.FindStringSubmatch(content)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// isDisambiguation reports whether the article is a disambiguation page. It
+// matches the {{disambiguation}} template, its shorthands like {{dab}} and
+// the specialized ones like {{human name disambiguation}}.
+func isDisambiguation(content string) bool {
+	return disambiguationRegexp.MatchString(content)
+}
+
+// followRedirects resolves chains of redirect pages starting with the already
+// extracted article and returns the first article that isn't a redirect along
+// with its location in the dump.
+func (h *TinyWikiHandler) followRedirects(ctx context.Context, title string, offsetAndId OffsetAndId, page *wikiPage) (string, OffsetAndId, *wikiPage, error) {
+	visited := map[string]bool{title: true}
+	for hops := 0; ; hops++ {
+		target, ok := redirectTarget(page.Text)
+		if !ok {
+			return title, offsetAndId, page, nil
+		}
+		if hops == maxRedirects {
+			return title, offsetAndId, page, errRedirectLoop
+		}
+		var err error
+		title, offsetAndId, page, err = h.lookupPage(ctx, target)
+		if err != nil {
+			return title, offsetAndId, page, err
+		}
+		if visited[title] {
+			return title, offsetAndId, page, errRedirectLoop
+		}
+		visited[title] = true
+	}
+}
+
+var notFoundTemplate = template.Must(template.New("notfound").Funcs(template.FuncMap{
+	"wikiURL": wikiURL,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Not found</title>
+<link rel="stylesheet" href="/tinypedia.css">
+</head>
+<body>
+<p>There is no article titled {{.Title}}.</p>
+{{with .Suggestions}}<p>Did you mean</p>
+<ul>
+{{range .}}<li><a href="{{wikiURL $.LinkBase .}}">{{.}}</a></li>
+{{end}}</ul>
+{{end}}` + searchForm + `</body>
+</html>
+`))
+
+// articleTemplate is the document around a rendered article
+var articleTemplate = template.Must(template.New("article").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="icon" href="/favicon.ico">
+<link rel="stylesheet" href="/tinypedia.css">
+` + openSearchLink + `
+</head>
+<body>
+<p><a href="/">Search</a></p>
+<h1>{{.Heading}}</h1>
+{{.Body}}</body>
+</html>
+`))
+
+// notFound responds with a 404 page suggesting titles similar to title,
+// negotiated like writeError
+func (h *TinyWikiHandler) notFound(w http.ResponseWriter, r *http.Request, title string) {
+	metrics.notFound.Add(1)
+	w.Header().Add("Vary", "Accept")
+	switch errorFormat(r) {
+	case "json":
+		writeJSON(w, http.StatusNotFound, notFoundJSON{"not found", suggestTitles(h.index(), title)})
+		return
+	case "text":
+		http.Error(w, "article not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	if r.Method == http.MethodHead {
+		return
+	}
+	err := notFoundTemplate.Execute(w, struct {
+		Title       string
+		Suggestions []string
+		LinkBase    string
+	}{title, suggestTitles(h.index(), title), h.linkBase})
+	if err != nil {
+		logError(err)
+	}
+}
+
+// allowReadMethods rejects requests other than GET and HEAD with a 405
+func allowReadMethods(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// lastModified returns when page was last edited or, if its revision has no
+// timestamp, when the dump was last modified
+func (h *TinyWikiHandler) lastModified(page *wikiPage) time.Time {
+	if modified, err := time.Parse(time.RFC3339, page.Timestamp); err == nil {
+		return modified
+	}
+	return h.data.Load().modTime
+}
+
+// setLastModified sends the Last-Modified header for page which writeBody
+// then uses for conditional requests
+func (h *TinyWikiHandler) setLastModified(w http.ResponseWriter, page *wikiPage) {
+	if modified := h.lastModified(page); !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// truncationMarker is appended to articles cut down to -maxbytes
+const truncationMarker = "\n\n''[Article truncated]''"
+
+// truncate cuts content down to the handler's maxBytes and announces it in
+// the X-Truncated header
+func (h *TinyWikiHandler) truncate(w http.ResponseWriter, content string) (string, bool) {
+	content, truncated := truncateContent(content, h.maxBytes)
+	if truncated {
+		w.Header().Set("X-Truncated", "true")
+	}
+	return content, truncated
+}
+
+// truncateContent cuts content to at most maxBytes bytes without splitting a
+// character. A maxBytes of 0 keeps it whole.
+func truncateContent(content string, maxBytes int) (string, bool) {
+	if maxBytes <= 0 || len(content) <= maxBytes {
+		return content, false
+	}
+	end := maxBytes
+	for end > 0 && !utf8.RuneStart(content[end]) {
+		end--
+	}
+	return content[:end], true
+}
+
+// writeBody writes body with the given content type and length. For HEAD
+// requests only the headers are written. The body's hash is sent as ETag and
+// a request already having it is answered with 304 Not Modified. Without an
+// If-None-Match header a Last-Modified header already set is compared to
+// If-Modified-Since instead.
+func writeBody(w http.ResponseWriter, r *http.Request, contentType, body string) {
+	hash := fnv.New64a()
+	io.WriteString(hash, body)
+	etag := `W/"` + strconv.FormatUint(hash.Sum64(), 16) + `"`
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if notModifiedSince(r.Header.Get("If-Modified-Since"), w.Header().Get("Last-Modified")) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.WriteString(w, body)
+}
+
+// etagMatches reports whether the If-None-Match header ifNoneMatch lists etag
+// using the weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModifiedSince reports whether the time of the Last-Modified header
+// lastModified isn't after that of the If-Modified-Since header
+// ifModifiedSince
+func notModifiedSince(ifModifiedSince, lastModified string) bool {
+	if ifModifiedSince == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	return err == nil && !modified.After(since)
+}
+
+func (h *TinyWikiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	metrics.requests.Add(1)
+	if !allowReadMethods(w, r) {
+		return
+	}
+	if strings.TrimSpace(r.URL.Path) == "" {
+		// Without a title send the user to the search page
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	if !checkTitle(w, r, func(w http.ResponseWriter, message string, status int) {
+		writeError(w, r, message, status)
+	}) {
+		return
+	}
+	if target, ok := h.alias(r.URL.Path); ok {
+		http.Redirect(w, r, wikiURL(h.linkBase, target), http.StatusFound)
+		return
+	}
+	if r.URL.Query().Get("action") == "raw" && r.URL.Query().Get("stream") == "1" {
+		h.streamRaw(w, r)
+		return
+	}
+	title, offsetAndId, page, err := h.lookupPage(r.Context(), r.URL.Path)
+	if err == nil && r.URL.Query().Get("action") != "raw" {
+		var target string
+		target, offsetAndId, page, err = h.followRedirects(r.Context(), title, offsetAndId, page)
+		if err == nil && target != title && r.URL.Query().Get("follow") != "1" {
+			http.Redirect(w, r, wikiURL(h.linkBase, target), http.StatusFound)
+			return
+		}
+		if err == nil {
+			title = target
+		}
+	}
+	noteTitle(r, title)
+	switch {
+	case err == errArticleNotFound:
+		h.notFound(w, r, title)
+		return
+	case err == errRedirectLoop:
+		logInfo("Redirect loop starting at", title)
+		writeError(w, r, "redirect loop", http.StatusLoopDetected)
+		return
+	case err != nil:
+		logError(err)
+		status, message := extractionErrorStatus(err)
+		writeError(w, r, message, status)
+		return
+	}
+	h.setLastModified(w, page)
+	content := page.Text
+	displayText, heading := displayTitle(title, page.Text, h.linkBase)
+	if r.URL.Query().Get("skipDab") == "1" && isDisambiguation(content) {
+		writeError(w, r, "disambiguation page", http.StatusNotFound)
+		return
+	}
+	if name := r.URL.Query().Get("section"); name != "" {
+		section, ok := extractSection(content, name)
+		if !ok {
+			writeError(w, r, "section not found", http.StatusNotFound)
+			return
+		}
+		content = section
+	}
+	content, truncated := h.truncate(w, content)
+	if r.URL.Query().Get("action") == "raw" {
+		if truncated {
+			content += truncationMarker
+		}
+		body, contentType := RawRenderer{}.Render(content)
+		writeBody(w, r, contentType, string(body))
+		return
+	}
+	if r.URL.Query().Get("view") == "source" {
+		if truncated {
+			content += truncationMarker
+		}
+		body, _ := SourceRenderer{}.Render(content)
+		writePage(w, r, articleTemplate, displayText, heading, string(body))
+		return
+	}
+	if r.URL.Query().Get("view") == "reader" {
+		if truncated {
+			content += truncationMarker
+		}
+		var ok bool
+		content, ok = handleRefs(content, r.URL.Query().Get("refs"))
+		if !ok {
+			writeError(w, r, "refs must be strip or collect", http.StatusBadRequest)
+			return
+		}
+		body, _ := ReaderRenderer{h.linkBase}.Render(content)
+		writePage(w, r, readerTemplate, displayText, heading, string(body))
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	format, ok := articleFormat(r)
+	if !ok {
+		writeError(w, r, "format must be html, json or text", http.StatusBadRequest)
+		return
+	}
+	if format == "json" {
+		writeJSONBody(w, r, articleJSON{title, offsetAndId.Id, offsetAndId.Offset, content, truncated})
+		return
+	}
+	if truncated {
+		content += truncationMarker
+	}
+	content, ok = handleRefs(content, r.URL.Query().Get("refs"))
+	if !ok {
+		writeError(w, r, "refs must be strip or collect", http.StatusBadRequest)
+		return
+	}
+	renderer := h.renderer
+	if format == "text" {
+		renderer = TextRenderer{}
+	}
+	rendered, contentType := renderer.Render(content)
+	if contentType == htmlContentType && r.URL.Query().Get("raw") != "1" {
+		writePage(w, r, articleTemplate, displayText, heading, string(rendered))
+		return
+	}
+	writeBody(w, r, contentType, string(rendered))
+}
+
+// writePage writes the HTML fragment body inside the document template
+// page, like articleTemplate, titled with the results of displayTitle
+func writePage(w http.ResponseWriter, r *http.Request, page *template.Template, title string, heading template.HTML, body string) {
+	var doc strings.Builder
+	err := page.Execute(&doc, struct {
+		Title   string
+		Heading template.HTML
+		Body    template.HTML
+	}{title, heading, template.HTML(body)})
+	if err != nil {
+		logError(err)
+		http.Error(w, "failed to render article", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, r, htmlContentType, doc.String())
+}
+
+// ServeText serves the article named by the request path as plain text with
+// all markup removed.
+func (h *TinyWikiHandler) ServeText(w http.ResponseWriter, r *http.Request) {
+	if !allowReadMethods(w, r) {
+		return
+	}
+	if !checkTitle(w, r, http.Error) {
+		return
+	}
+	title, _, page, err := h.lookupPage(r.Context(), r.URL.Path)
+	noteTitle(r, title)
+	if err == errArticleNotFound {
+		http.Error(w, "article not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logError(err)
+		status, message := extractionErrorStatus(err)
+		http.Error(w, message, status)
+		return
+	}
+	h.setLastModified(w, page)
+	if r.URL.Query().Get("skipDab") == "1" && isDisambiguation(page.Text) {
+		http.Error(w, "disambiguation page", http.StatusNotFound)
+		return
+	}
+	content, truncated := h.truncate(w, page.Text)
+	if truncated {
+		content += truncationMarker
+	}
+	content, ok := handleRefs(content, r.URL.Query().Get("refs"))
+	if !ok {
+		http.Error(w, "refs must be strip or collect", http.StatusBadRequest)
+		return
+	}
+	writeBody(w, r, "text/plain; charset=utf-8", normalizeWhitespace(stripWikitext(content)))
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentRequests(t *testing.T) {
+	h := newTestHandler(t)
+	want := map[string]string{
+		"Alan_Turing":   "'''Alan Mathison Turing''' was an English",
+		"New_York_City": "'''New York City''' is a city",
+		"Éclair":        "An '''éclair''' is a pastry",
+	}
+	for i := 1; i <= 100; i++ {
+		want[fmt.Sprintf("Sample_%03d", i)] = fmt.Sprintf("Sample page number %d about", i)
+	}
+	routes := wikiRoute(h)
+	var wg sync.WaitGroup
+	for round := 0; round < 4; round++ {
+		for title, text := range want {
+			wg.Add(1)
+			go func(title, text string) {
+				defer wg.Done()
+				w := get(routes, "/wiki/"+title+"?action=raw")
+				if w.Code != http.StatusOK {
+					t.Errorf("%s: got status %d", title, w.Code)
+				} else if !strings.Contains(w.Body.String(), text) {
+					t.Errorf("%s: got %.60q, want it to contain %q", title, w.Body.String(), text)
+				}
+			}(title, text)
+		}
+	}
+	wg.Wait()
+}
+
+func TestArticleJSONFollowsRedirects(t *testing.T) {
+	h := newTestHandler(t)
+	tests := []struct {
+		target string
+		want   articleJSON
+	}{
+		{"/wiki/Alan_Turing?format=json", articleJSON{Title: "Alan Turing", Id: 10, Offset: 150}},
+		{"/wiki/NYC?format=json&follow=1", articleJSON{Title: "New York City", Id: 12, Offset: 150}},
+		{"/wiki/Big_Apple?format=json&follow=1", articleJSON{Title: "New York City", Id: 12, Offset: 150}},
+	}
+	for _, tt := range tests {
+		rec := get(wikiRoute(h), tt.target)
+		var got articleJSON
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %q", tt.target, rec.Code, rec.Body.String())
+		}
+		if got.Title != tt.want.Title || got.Id != tt.want.Id || got.Offset != tt.want.Offset {
+			t.Errorf("%s: got %s %d at %d, want %s %d at %d", tt.target, got.Title, got.Id, got.Offset, tt.want.Title, tt.want.Id, tt.want.Offset)
+		}
+	}
+}
+
+func TestFollowRedirects(t *testing.T) {
+	h := newTestHandler(t)
+	// R0 to R6 form a chain longer than maxRedirects
+	var pages []string
+	for i := 0; i <= maxRedirects+1; i++ {
+		pages = append(pages, fmt.Sprintf("R%d", i), fmt.Sprintf("#REDIRECT [[R%d]]", i+1))
+	}
+	pages = append(pages, fmt.Sprintf("R%d", maxRedirects+2), "End of the chain")
+	indexPath, contentPath := writeGzipDump(t, pages...)
+	chain := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	tests := []struct {
+		name     string
+		h        *TinyWikiHandler
+		target   string
+		status   int
+		location string
+		body     string
+	}{
+		{"single", h, "/wiki/NYC", http.StatusFound, "/wiki/New_York_City", ""},
+		{"single followed", h, "/wiki/NYC?follow=1&format=text", http.StatusOK, "", "New York City is a city"},
+		{"two hops", h, "/wiki/Big_Apple", http.StatusFound, "/wiki/New_York_City", ""},
+		{"two hops followed", h, "/wiki/Big_Apple?follow=1&format=text", http.StatusOK, "", "New York City is a city"},
+		{"raw redirect", h, "/wiki/Big_Apple?action=raw", http.StatusOK, "", "#REDIRECT [[NYC]]"},
+		{"self loop", h, "/wiki/Loop?format=text", http.StatusLoopDetected, "", "redirect loop"},
+		{"loop limit", chain, "/wiki/R0?format=text", http.StatusLoopDetected, "", "redirect loop"},
+		{"within the limit", chain, "/wiki/R2?follow=1&format=text", http.StatusOK, "", "End of the chain"},
+	}
+	for _, tt := range tests {
+		rec := get(wikiRoute(tt.h), tt.target)
+		if rec.Code != tt.status || rec.Header().Get("Location") != tt.location || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("%s: got %d to %q with %q, want %d to %q containing %q", tt.name, rec.Code, rec.Header().Get("Location"), rec.Body.String(), tt.status, tt.location, tt.body)
+		}
+	}
+}
+
+func TestRequestMethods(t *testing.T) {
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	mux.Handle("/wiki/", wikiRoute(h))
+	wikiRoutes(mux, "", h)
+	for _, target := range []string{"/wiki/Alan_Turing", "/wiki/Alan_Turing?action=raw", "/text/Alan_Turing", "/wiki/Missing_article"} {
+		getRec := get(mux, target)
+		headRec := httptest.NewRecorder()
+		mux.ServeHTTP(headRec, httptest.NewRequest(http.MethodHead, target, nil))
+		if headRec.Code != getRec.Code || headRec.Body.Len() != 0 {
+			t.Errorf("HEAD %s: got %d with %d bytes, want %d without body", target, headRec.Code, headRec.Body.Len(), getRec.Code)
+		}
+		for _, name := range []string{"Content-Type", "Content-Length", "ETag"} {
+			if headRec.Header().Get(name) != getRec.Header().Get(name) {
+				t.Errorf("HEAD %s: got %s %q, GET got %q", target, name, headRec.Header().Get(name), getRec.Header().Get(name))
+			}
+		}
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader("x")))
+			if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD" {
+				t.Errorf("%s %s: got %d allowing %q, want %d allowing GET, HEAD", method, target, rec.Code, rec.Header().Get("Allow"), http.StatusMethodNotAllowed)
+			}
+		}
+	}
+}
+
+func TestServeSection(t *testing.T) {
+	routes := wikiRoute(newTestHandler(t))
+	tests := []struct {
+		target string
+		status int
+		want   string
+	}{
+		{"/wiki/Alan_Turing?section=Career&action=raw", http.StatusOK, "== Career ==\nHe worked at [[Bletchley Park#Huts|Bletchley]] on [[cryptanalysis]].\n\n[[Category:Mathematicians]]\n[[Category:1912 births|Turing]]\n"},
+		{"/wiki/Alan_Turing?section=early+life&action=raw", http.StatusOK, "== Early life ==\nBorn in [[Maida Vale]].\n\n=== School ===\n* item one\n* item ''two''\n"},
+		{"/wiki/Alan_Turing?section=School&action=raw", http.StatusOK, "=== School ===\n* item one\n* item ''two''\n"},
+		{"/wiki/Alan_Turing?section=Death&action=raw", http.StatusNotFound, "section not found"},
+		{"/wiki/Alan_Turing?section=Career", http.StatusOK, "Bletchley"},
+	}
+	for _, tt := range tests {
+		rec := get(routes, tt.target)
+		raw := strings.HasSuffix(tt.target, "action=raw") && tt.status == http.StatusOK
+		if rec.Code != tt.status || (raw && rec.Body.String() != tt.want) || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%s: got %d %q, want %d with %q", tt.target, rec.Code, rec.Body.String(), tt.status, tt.want)
+		}
+	}
+	if rec := get(routes, "/wiki/Alan_Turing?section=Career"); strings.Contains(rec.Body.String(), "Maida Vale") {
+		t.Errorf("section Career contains text of Early life: %q", rec.Body.String())
+	}
+}
+
+func TestArticleETag(t *testing.T) {
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	mux.Handle("/wiki/", wikiRoute(h))
+	wikiRoutes(mux, "", h)
+	for _, target := range []string{"/wiki/Alan_Turing", "/wiki/Alan_Turing?action=raw", "/wiki/Alan_Turing?format=json", "/api/article/Alan_Turing", "/text/Alan_Turing"} {
+		rec := get(mux, target)
+		etag := rec.Header().Get("ETag")
+		if rec.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%s: got %d with ETag %q", target, rec.Code, etag)
+		}
+		if again := get(mux, target); again.Header().Get("ETag") != etag {
+			t.Errorf("%s: ETag changed from %q to %q", target, etag, again.Header().Get("ETag"))
+		}
+		if rec := get(mux, target, "If-None-Match", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%s with its ETag: got %d %q", target, rec.Code, rec.Body.String())
+		}
+		if rec := get(mux, target, "If-None-Match", `W/"other", `+etag); rec.Code != http.StatusNotModified {
+			t.Errorf("%s with its ETag in a list: got %d", target, rec.Code)
+		}
+		other := strings.Replace(target, "Alan_Turing", "New_York_City", 1)
+		if rec := get(mux, other, "If-None-Match", etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+			t.Errorf("%s with the ETag of %s: got %d with ETag %q", other, target, rec.Code, rec.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	mux.Handle("/wiki/", wikiRoute(h))
+	wikiRoutes(mux, "", h)
+	const edited = "Fri, 02 Mar 2018 12:00:00 GMT"
+	for _, target := range []string{"/wiki/Alan_Turing", "/wiki/Alan_Turing?action=raw", "/wiki/Alan_Turing?format=json", "/text/Alan_Turing"} {
+		rec := get(mux, target)
+		if modified := rec.Header().Get("Last-Modified"); rec.Code != http.StatusOK || modified != edited {
+			t.Fatalf("%s: got %d with Last-Modified %q, want %q", target, rec.Code, modified, edited)
+		}
+		tests := []struct {
+			header []string
+			status int
+		}{
+			{[]string{"If-Modified-Since", edited}, http.StatusNotModified},
+			{[]string{"If-Modified-Since", "Sat, 03 Mar 2018 12:00:00 GMT"}, http.StatusNotModified},
+			{[]string{"If-Modified-Since", "Fri, 02 Mar 2018 11:59:59 GMT"}, http.StatusOK},
+			{[]string{"If-Modified-Since", "yesterday"}, http.StatusOK},
+			// If-None-Match takes precedence
+			{[]string{"If-Modified-Since", edited, "If-None-Match", `W/"other"`}, http.StatusOK},
+		}
+		for _, tt := range tests {
+			if rec := get(mux, target, tt.header...); rec.Code != tt.status || (tt.status == http.StatusNotModified && rec.Body.Len() != 0) {
+				t.Errorf("%s with %q: got %d with %d bytes, want %d", target, tt.header, rec.Code, rec.Body.Len(), tt.status)
+			}
+		}
+	}
+
+	// Without a revision timestamp the dump's modification time is sent
+	indexPath, contentPath := writeGzipDump(t, "Undated", "No timestamp here")
+	info, err := os.Stat(contentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	undated := wikiRoute(loadTestWiki(t, indexPath, contentPath, defaultLinkBase))
+	want := info.ModTime().UTC().Format(http.TimeFormat)
+	if rec := get(undated, "/wiki/Undated"); rec.Header().Get("Last-Modified") != want {
+		t.Errorf("undated article: got Last-Modified %q, want %q", rec.Header().Get("Last-Modified"), want)
+	}
+	if rec := get(undated, "/wiki/Undated", "If-Modified-Since", want); rec.Code != http.StatusNotModified {
+		t.Errorf("undated article since %s: got %d", want, rec.Code)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	// The cut at byte 10 falls into the û of brûlée
+	const text, cut = "Crème brûlée is a dessert", "Crème br"
+	if got, truncated := truncateContent(text, 10); got != cut || !truncated {
+		t.Errorf("truncateContent at 10: got %q, %v", got, truncated)
+	}
+	for _, limit := range []int{0, len(text), len(text) + 1} {
+		if got, truncated := truncateContent(text, limit); got != text || truncated {
+			t.Errorf("truncateContent at %d: got %q, %v", limit, got, truncated)
+		}
+	}
+
+	defer func(limit int) { maxBytes = limit }(maxBytes)
+	maxBytes = 10
+	indexPath, contentPath := writeGzipDump(t, "Dessert", text, "Short", "Small")
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	mux := http.NewServeMux()
+	mux.Handle("/wiki/", wikiRoute(h))
+	wikiRoutes(mux, "", h)
+	rec := get(mux, "/wiki/Dessert?action=raw")
+	if rec.Body.String() != cut+truncationMarker || rec.Header().Get("X-Truncated") != "true" {
+		t.Errorf("oversized raw: got %q with X-Truncated %q", rec.Body.String(), rec.Header().Get("X-Truncated"))
+	}
+	if rec := get(mux, "/wiki/Dessert"); !strings.Contains(rec.Body.String(), "Article truncated") || rec.Header().Get("X-Truncated") != "true" {
+		t.Errorf("oversized HTML: got %q with X-Truncated %q", rec.Body.String(), rec.Header().Get("X-Truncated"))
+	}
+	for _, target := range []string{"/wiki/Dessert?format=json", "/api/article/Dessert"} {
+		var article articleJSON
+		if rec := get(mux, target); json.Unmarshal(rec.Body.Bytes(), &article) != nil || article.Content != cut || !article.Truncated {
+			t.Errorf("%s: got %q", target, rec.Body.String())
+		}
+	}
+
+	rec = get(mux, "/wiki/Short?action=raw")
+	if rec.Body.String() != "Small" || rec.Header().Get("X-Truncated") != "" {
+		t.Errorf("under the limit: got %q with X-Truncated %q", rec.Body.String(), rec.Header().Get("X-Truncated"))
+	}
+	if rec := get(mux, "/api/article/Short"); strings.Contains(rec.Body.String(), "truncated") {
+		t.Errorf("under the limit JSON: got %q", rec.Body.String())
+	}
+}
+
+func TestIsDisambiguation(t *testing.T) {
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"'''Mercury''' may refer to:\n{{disambiguation}}", true},
+		{"{{Disambiguation|geo}}", true},
+		{"{{ dab }}", true},
+		{"{{disambig}}", true},
+		{"{{hndis|Smith, John}}", true},
+		{"{{geodis}}", true},
+		{"{{Human name disambiguation}}", true},
+		{"{{Disambiguation needed}}", false},
+		{"{{Infobox planet}} Mercury is a planet.", false},
+		{"See the disambiguation page.", false},
+	}
+	for _, tt := range tests {
+		if got := isDisambiguation(tt.content); got != tt.want {
+			t.Errorf("isDisambiguation(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+
+	routes := wikiRoute(newTestHandler(t))
+	for target, status := range map[string]int{
+		"/wiki/Mercury":                       http.StatusOK,
+		"/wiki/Mercury?skipDab=1":             http.StatusNotFound,
+		"/wiki/Mercury?skipDab=1&format=json": http.StatusNotFound,
+		"/wiki/Alan_Turing?skipDab=1":         http.StatusOK,
+	} {
+		if rec := get(routes, target); rec.Code != status {
+			t.Errorf("%s: got %d, want %d", target, rec.Code, status)
+		}
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	h := newTestHandler(b)
+	for _, target := range []string{"/wiki/Alan_Turing", "/wiki/Alan_Turing?action=raw", "/wiki/Sample_100"} {
+		b.Run(strings.TrimPrefix(target, "/wiki/"), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if rec := get(wikiRoute(h), target); rec.Code != http.StatusOK {
+					b.Fatalf("got %d", rec.Code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ServeHealth reports whether the handler is ready to serve articles, that is
+// whether its index contains any titles and a local content file is still
+// there.
+func (h *TinyWikiHandler) ServeHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	index := h.index()
+	if index.Len() == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "not ready: index is empty")
+		return
+	}
+	if !isURL(h.contentFilePath) {
+		if _, err := statContent(h.contentFilePath); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "not ready: content file unavailable")
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok:", index.Len(), "titles indexed")
+}
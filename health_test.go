@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeHealth(t *testing.T) {
+	dir := t.TempDir()
+	indexPath, contentPath := filepath.Join(dir, "index.txt.bz2"), filepath.Join(dir, "dump.xml.bz2")
+	copyFile(t, testIndexPath, indexPath)
+	copyFile(t, testContentPath, contentPath)
+	loaded := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	empty := NewTinyWikiHandler(newMapIndex(map[string]OffsetAndId{}), dumpSource{path: testContentPath}, defaultLinkBase, 0, 0)
+
+	rec := get(http.HandlerFunc(loaded.ServeHealth), "/healthz")
+	if want := "ok: 107 titles indexed\n"; rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("loaded: got %d %q, want %d %q", rec.Code, rec.Body.String(), http.StatusOK, want)
+	}
+	rec = get(http.HandlerFunc(empty.ServeHealth), "/healthz")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "index is empty") {
+		t.Errorf("empty: got %d %q, want %d", rec.Code, rec.Body.String(), http.StatusServiceUnavailable)
+	}
+	if err := os.Remove(contentPath); err != nil {
+		t.Fatal(err)
+	}
+	rec = get(http.HandlerFunc(loaded.ServeHealth), "/healthz")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "content file unavailable") {
+		t.Errorf("content file removed: got %d %q, want %d", rec.Code, rec.Body.String(), http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type OffsetAndId struct {
+	Offset int64
+	Id     uint64
+}
+
+// indexBatchSize is the number of index lines handed to a parsing worker at
+// once
+const indexBatchSize = 4096
+
+type indexEntry struct {
+	title       string
+	offsetAndId OffsetAndId
+}
+
+type indexBatch struct {
+	seq   int
+	lines []string
+}
+
+type indexBatchResult struct {
+	seq     int
+	entries []indexEntry
+}
+
+// parseIndexLines parses lines of the form offset:id:title. For a split dump
+// the lines start with the name of the stream file the offset is relative to
+// instead, they are translated to offsets into the split dump using
+// fileBases.
+func parseIndexLines(lines []string, namespaces namespaceSet, fileBases map[string]int64) []indexEntry {
+	fields := 3
+	if fileBases != nil {
+		fields = 4
+	}
+	entries := make([]indexEntry, 0, len(lines))
+	for _, line := range lines {
+		splits := strings.SplitN(line, ":", fields)
+		if len(splits) < fields {
+			logError("Skipping malformed index line", strconv.Quote(line))
+			continue
+		}
+		var base int64
+		if fileBases != nil {
+			var ok bool
+			if base, ok = fileBases[splits[0]]; !ok {
+				logError("Skipping index line for unknown stream file", strconv.Quote(line))
+				continue
+			}
+			splits = splits[1:]
+		}
+		offStr, idStr, currTitle := splits[0], splits[1], splits[2]
+		if !namespaces.allows(currTitle) {
+			continue
+		}
+		offset, err := strconv.ParseInt(offStr, 10, 64)
+		if err != nil {
+			logError(err)
+			continue
+		}
+		offset += base
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			logError(err)
+			continue
+		}
+		entries = append(entries, indexEntry{currTitle, OffsetAndId{offset, id}})
+	}
+	return entries
+}
+
+// readStreamOffsetAndId builds the offset map from a multistream index file.
+// Decompression happens sequentially while the lines are parsed by a pool of
+// workers. The parsed batches are merged in their original order so that the
+// last line wins for duplicate titles just like in a sequential scan. Titles
+// outside of namespaces are left out.
+func readStreamOffsetAndId(indexFile *os.File, namespaces namespaceSet, fileBases map[string]int64) (map[string]OffsetAndId, error) {
+	decompress, err := decompressorFor(indexFile.Name())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := indexFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	indexStream, err := decompress(indexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	batches := make(chan indexBatch)
+	results := make(chan indexBatchResult)
+	var workers sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for batch := range batches {
+				results <- indexBatchResult{batch.seq, parseIndexLines(batch.lines, namespaces, fileBases)}
+			}
+		}()
+	}
+
+	var scanErr error
+	go func() {
+		defer close(batches)
+		indexScanner := bufio.NewScanner(indexStream)
+		batch := indexBatch{0, make([]string, 0, indexBatchSize)}
+		for indexScanner.Scan() {
+			batch.lines = append(batch.lines, indexScanner.Text())
+			if len(batch.lines) == indexBatchSize {
+				batches <- batch
+				batch = indexBatch{batch.seq + 1, make([]string, 0, indexBatchSize)}
+			}
+		}
+		if len(batch.lines) > 0 {
+			batches <- batch
+		}
+		scanErr = indexScanner.Err()
+	}()
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	offsetMap := make(map[string]OffsetAndId)
+	pending := make(map[int][]indexEntry)
+	next := 0
+	for result := range results {
+		pending[result.seq] = result.entries
+		for entries, ok := pending[next]; ok; entries, ok = pending[next] {
+			for _, entry := range entries {
+				offsetMap[entry.title] = entry.offsetAndId
+			}
+			delete(pending, next)
+			next++
+		}
+	}
+	return offsetMap, scanErr
+}
+
+// loadOffsetMap reads the offset map from the index file, going through the
+// cache at cachePath if one is given. An index given by URL is downloaded
+// first unless the size and modification time the server reports for it
+// match the cache. fileBases are the stream files of a split dump as returned
+// by dumpSource.fileBases.
+func loadOffsetMap(indexPath, cachePath string, namespaces namespaceSet, fileBases map[string]int64) (map[string]OffsetAndId, error) {
+	if isURL(indexPath) {
+		if cachePath != "" {
+			// Without a modification time from the server the download
+			// gets the current time and never matches the cache anyway
+			info, err := (&remoteFile{url: indexPath, ctx: context.Background()}).Stat()
+			if err == nil && !info.ModTime().IsZero() {
+				if offsetMap, err := loadIndexCache(cachePath, info, namespaces, fileBases); err == nil {
+					logInfo("Loaded index from cache", cachePath, "as", indexPath, "is unchanged")
+					return offsetMap, nil
+				}
+			}
+		}
+		dir, err := os.MkdirTemp("", "tinypedia-index")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		logInfo("Downloading index", indexPath)
+		if indexPath, err = downloadIndex(indexPath, dir); err != nil {
+			return nil, err
+		}
+	}
+	indexFile, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer indexFile.Close()
+	indexInfo, err := indexFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if cachePath != "" {
+		offsetMap, err := loadIndexCache(cachePath, indexInfo, namespaces, fileBases)
+		if err == nil {
+			logInfo("Loaded index from cache", cachePath)
+			return offsetMap, nil
+		}
+		if !os.IsNotExist(err) {
+			logInfo("Ignoring index cache:", err)
+		}
+	}
+
+	offsetMap, err := readStreamOffsetAndId(indexFile, namespaces, fileBases)
+	if err != nil {
+		return nil, err
+	}
+
+	if cachePath != "" {
+		if err := writeIndexCache(cachePath, indexInfo, namespaces, fileBases, offsetMap); err != nil {
+			logError("Couldn't write index cache:", err)
+		} else {
+			logInfo("Wrote index cache", cachePath)
+		}
+	}
+	return offsetMap, nil
+}
+
+// dropInvalidOffsets removes the titles whose offset lies outside of the
+// content file of dump from offsetMap, so a mismatch between index and dump
+// shows at start and not with the first requests for them
+func dropInvalidOffsets(offsetMap map[string]OffsetAndId, dump dumpSource) error {
+	if dump.decompressed {
+		// The offsets were found by reading the dump itself and may lie
+		// beyond the size of the compressed file
+		return nil
+	}
+	info, err := dump.stat()
+	if err != nil {
+		return err
+	}
+	dropped := 0
+	for title, offsetAndId := range offsetMap {
+		if offsetAndId.Offset < 0 || offsetAndId.Offset >= info.Size() {
+			logDebug("Dropping", title, "at offset", offsetAndId.Offset, "outside of the content file")
+			delete(offsetMap, title)
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		logError("Dropped", dropped, "titles with offsets outside of the", info.Size(), "bytes of", dump.path)
+	}
+	return nil
+}
+
+// streamOffsets returns the sorted start offsets of all streams in index
+func streamOffsets(index titleIndex) []int64 {
+	var offsets []int64
+	seen := make(map[int64]bool)
+	for i := 0; i < index.Len(); i++ {
+		offsetAndId, _ := index.Lookup(index.Title(i))
+		if !seen[offsetAndId.Offset] {
+			seen[offsetAndId.Offset] = true
+			offsets = append(offsets, offsetAndId.Offset)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+	return offsets
+}
+
+// streamEnd returns the offset of the stream following the one at offset or
+// -1 if it is the last one
+func streamEnd(offsets []int64, offset int64) int64 {
+	i := sort.Search(len(offsets), func(i int) bool {
+		return offsets[i] > offset
+	})
+	if i == len(offsets) {
+		return -1
+	}
+	return offsets[i]
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeIndex writes an uncompressed index of n titles in streams of 100
+// pages to a temporary file and opens it
+func writeIndex(t testing.TB, n int, extra ...string) *os.File {
+	t.Helper()
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%d:%d:Title %d\n", 1000*(i/100), i+1, i)
+	}
+	for _, line := range extra {
+		b.WriteString(line + "\n")
+	}
+	path := filepath.Join(t.TempDir(), "index.txt")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestReadIndexLastLineWins(t *testing.T) {
+	// The duplicate lands in a later batch than the first line of the title
+	f := writeIndex(t, 3*indexBatchSize, "9999:1:Title 0")
+	offsetMap, err := readStreamOffsetAndId(f, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsetMap) != 3*indexBatchSize {
+		t.Errorf("got %d titles, want %d", len(offsetMap), 3*indexBatchSize)
+	}
+	if got := offsetMap["Title 0"]; got != (OffsetAndId{9999, 1}) {
+		t.Errorf("Title 0 = %v, want the last line's {9999 1}", got)
+	}
+	if got := offsetMap["Title 250"]; got != (OffsetAndId{2000, 251}) {
+		t.Errorf("Title 250 = %v, want {2000 251}", got)
+	}
+}
+
+func TestReadIndexTruncatedLastLine(t *testing.T) {
+	// A download cut short ends the index in the middle of a line
+	for _, last := range []string{"2000:202:Title 201", "2000:202", "2000:", "20"} {
+		path := filepath.Join(t.TempDir(), "index.txt")
+		if err := os.WriteFile(path, []byte("0:1:Title 0\n1000:201:Title 200\n"+last), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		offsetMap, err := readStreamOffsetAndId(f, nil, nil)
+		if err != nil {
+			t.Fatalf("%q: %v", last, err)
+		}
+		want := map[string]OffsetAndId{"Title 0": {0, 1}, "Title 200": {1000, 201}}
+		if strings.Count(last, ":") == 2 {
+			want["Title 201"] = OffsetAndId{2000, 202}
+		}
+		if !reflect.DeepEqual(offsetMap, want) {
+			t.Errorf("ending in %q: got %v, want %v", last, offsetMap, want)
+		}
+	}
+}
+
+// BenchmarkParseIndexWorkers compares parsing with a single worker to
+// parsing with one worker per CPU
+func BenchmarkParseIndexWorkers(b *testing.B) {
+	f := writeIndex(b, 200000)
+	procs := []int{1}
+	if runtime.NumCPU() > 1 {
+		procs = append(procs, runtime.NumCPU())
+	}
+	for _, procs := range procs {
+		b.Run(fmt.Sprintf("procs=%d", procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			for i := 0; i < b.N; i++ {
+				if _, err := readStreamOffsetAndId(f, nil, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadOffsetMapFromURL(t *testing.T) {
+	index, err := os.ReadFile(testIndexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			downloads.Add(1)
+		}
+		http.ServeContent(w, r, "index.txt.bz2", modTime, bytes.NewReader(index))
+	}))
+	defer server.Close()
+
+	cachePath := filepath.Join(t.TempDir(), "index.cache")
+	load := func() map[string]OffsetAndId {
+		t.Helper()
+		offsetMap, err := loadOffsetMap(server.URL+"/index.txt.bz2", cachePath, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return offsetMap
+	}
+	want := load()
+	if got := load(); !reflect.DeepEqual(got, want) {
+		t.Errorf("cached index differs: got %d titles, want %d", len(got), len(want))
+	}
+	if got := downloads.Load(); got != 1 {
+		t.Errorf("unchanged index downloaded %d times, want once", got)
+	}
+	modTime = modTime.Add(time.Hour)
+	load()
+	if got := downloads.Load(); got != 2 {
+		t.Errorf("changed index downloaded %d times in total, want twice", got)
+	}
+}
+
+func BenchmarkReadIndex(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := loadOffsetMap(testIndexPath, "", nil, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDropInvalidOffsets(t *testing.T) {
+	info, err := os.Stat(testContentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsetMap := map[string]OffsetAndId{
+		"First":    {0, 1},
+		"Last":     {info.Size() - 1, 2},
+		"At end":   {info.Size(), 3},
+		"Beyond":   {info.Size() + 1000, 4},
+		"Negative": {-1, 5},
+	}
+	if err := dropInvalidOffsets(offsetMap, dumpSource{path: testContentPath}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]OffsetAndId{"First": {0, 1}, "Last": {info.Size() - 1, 2}}
+	if !reflect.DeepEqual(offsetMap, want) {
+		t.Errorf("got %v, want %v", offsetMap, want)
+	}
+}
+
+func TestLoadWikiValidatesOffsets(t *testing.T) {
+	indexPath, contentPath := writeGzipDump(t, "Kept", "A page in the dump")
+	index, err := os.OpenFile(indexPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(index, "99999999:2:Beyond")
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	validateOffsets = true
+	defer func() { validateOffsets = false }()
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	if _, ok := h.index().Lookup("Beyond"); ok {
+		t.Error("title beyond the end of the dump was kept")
+	}
+	if rec := get(wikiRoute(h), "/wiki/Kept?action=raw"); rec.Code != 200 || rec.Body.String() != "A page in the dump" {
+		t.Errorf("valid title: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBuildIndexRoundTrip(t *testing.T) {
+	want, err := loadOffsetMap(testIndexPath, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"index.txt", "index.txt.gz"} {
+		indexPath := filepath.Join(t.TempDir(), name)
+		pages, err := writeIndexFile(testContentPath, indexPath)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := loadOffsetMap(indexPath, "", nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if pages != len(want) || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %d pages and %d titles differing from the original index of %d", name, pages, len(got), len(want))
+		}
+		h := loadTestWiki(t, indexPath, testContentPath, defaultLinkBase)
+		if rec := get(wikiRoute(h), "/wiki/Alan_Turing?action=raw"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Alan Mathison Turing") {
+			t.Errorf("%s: got %d %.60q", name, rec.Code, rec.Body.String())
+		}
+	}
+	if _, err := writeIndexFile(testContentPath, filepath.Join(t.TempDir(), "index.txt.bz2")); err != errBzip2Output {
+		t.Errorf("bzip2 output: got %v, want %v", err, errBzip2Output)
+	}
+	if _, err := writeIndexFile("testdata/gzip.xml.gz", filepath.Join(t.TempDir(), "index.txt")); err != errNotBzip2Multistream {
+		t.Errorf("gzip dump: got %v, want %v", err, errNotBzip2Multistream)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var errStaleIndexCache = errors.New("index cache is stale")
+
+// indexCache is the on-disk representation of an offset map. The size and
+// modification time of the index file it was built from as well as the
+// namespaces it was filtered by are stored alongside so a cache for an older
+// dump or different settings is never used. The offsets of a split dump also
+// depend on the sizes of its stream files, so their base offsets are stored as
+// well.
+type indexCache struct {
+	SourceSize    int64
+	SourceModTime time.Time
+	Namespaces    string
+	FileBases     map[string]int64
+	OffsetMap     map[string]OffsetAndId
+}
+
+func loadIndexCache(cachePath string, source os.FileInfo, namespaces namespaceSet, fileBases map[string]int64) (map[string]OffsetAndId, error) {
+	cacheFile, err := os.Open(cachePath)
+	if err != nil {
+		return nil, err
+	}
+	defer cacheFile.Close()
+
+	var cache indexCache
+	if err := gob.NewDecoder(bufio.NewReader(cacheFile)).Decode(&cache); err != nil {
+		return nil, err
+	}
+	if cache.SourceSize != source.Size() || !cache.SourceModTime.Equal(source.ModTime()) ||
+		cache.Namespaces != namespaces.String() || !maps.Equal(cache.FileBases, fileBases) {
+		return nil, errStaleIndexCache
+	}
+	return cache.OffsetMap, nil
+}
+
+func writeIndexCache(cachePath string, source os.FileInfo, namespaces namespaceSet, fileBases map[string]int64, offsetMap map[string]OffsetAndId) error {
+	// Write to a temporary file first so a crash never leaves a truncated
+	// cache behind
+	tmpFile, err := os.CreateTemp(filepath.Dir(cachePath), filepath.Base(cachePath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	buffered := bufio.NewWriter(tmpFile)
+	cache := indexCache{source.Size(), source.ModTime(), namespaces.String(), fileBases, offsetMap}
+	if err := gob.NewEncoder(buffered).Encode(&cache); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), cachePath)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var infoboxStartRegexp = regexp.MustCompile(`(?i)\{\{\s*infobox`)
+
+// parseInfobox parses the first {{Infobox ...}} template of an article into
+// the template's name and its parameters. Pipes inside nested templates and
+// links don't separate parameters.
+func parseInfobox(wikitext string) (name string, fields map[string]string, ok bool) {
+	loc := infoboxStartRegexp.FindStringIndex(wikitext)
+	if loc == nil {
+		return "", nil, false
+	}
+	text := wikitext[loc[0]:]
+	end := balancedEnd(text, "{{", "}}")
+	if end < 0 {
+		return "", nil, false
+	}
+	params := splitTemplateParams(text[2 : end-2])
+	name = strings.TrimSpace(params[0])
+	fields = make(map[string]string)
+	for i, param := range params[1:] {
+		key, value, named := strings.Cut(param, "=")
+		if !named {
+			key, value = strconv.Itoa(i+1), param
+		}
+		fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return name, fields, true
+}
+
+// splitTemplateParams splits the inside of a template at all pipes that are
+// not nested in another template or link.
+func splitTemplateParams(inner string) []string {
+	var params []string
+	depth, start := 0, 0
+	for i := 0; i < len(inner); i++ {
+		switch {
+		case strings.HasPrefix(inner[i:], "{{"), strings.HasPrefix(inner[i:], "[["):
+			depth++
+			i++
+		case strings.HasPrefix(inner[i:], "}}"), strings.HasPrefix(inner[i:], "]]"):
+			depth--
+			i++
+		case inner[i] == '|' && depth == 0:
+			params = append(params, inner[start:i])
+			start = i + 1
+		}
+	}
+	return append(params, inner[start:])
+}
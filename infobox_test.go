@@ -0,0 +1,46 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseInfobox(t *testing.T) {
+	tests := []struct {
+		name, wikitext, infobox string
+		fields                  map[string]string
+		ok                      bool
+	}{
+		{
+			"nested template and link",
+			"Lead.\n{{Infobox scientist\n| name = Alan Turing\n| birth_date = {{birth date|1912|6|23}}\n| field = [[Mathematics|Maths]]\n}}\nText.",
+			"Infobox scientist",
+			map[string]string{"name": "Alan Turing", "birth_date": "{{birth date|1912|6|23}}", "field": "[[Mathematics|Maths]]"},
+			true,
+		},
+		{
+			"positional and empty parameters",
+			"{{infobox city|London| country = |population=8,800,000}}",
+			"infobox city",
+			map[string]string{"1": "London", "country": "", "population": "8,800,000"},
+			true,
+		},
+		{
+			"first of two",
+			"{{Infobox a|x=1}} {{Infobox b|x=2}}",
+			"Infobox a",
+			map[string]string{"x": "1"},
+			true,
+		},
+		{"unclosed", "{{Infobox a|x={{b}}", "", nil, false},
+		{"none", "{{Other|x=1}} Text.", "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, fields, ok := parseInfobox(tt.wikitext)
+			if name != tt.infobox || ok != tt.ok || !maps.Equal(fields, tt.fields) {
+				t.Errorf("parseInfobox(%q) = %q, %v, %v, want %q, %v, %v", tt.wikitext, name, fields, ok, tt.infobox, tt.fields, tt.ok)
+			}
+		})
+	}
+}
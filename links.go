@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+)
+
+// categoryLink is the membership of an article in a category declared by
+// [[Category:Name|sort key]]. The sort key is empty unless given.
+type categoryLink struct {
+	Name, SortKey string
+}
+
+// eachLink calls fn with the inside of every internal [[...]] link of
+// wikitext, including the links nested in the captions of files.
+func eachLink(wikitext string, fn func(link string)) {
+	links := newDelimiterScanner("[[", "]]")
+	for {
+		start := strings.Index(wikitext, "[[")
+		if start < 0 {
+			return
+		}
+		wikitext = wikitext[start:]
+		end := links.end(wikitext)
+		if end < 0 {
+			wikitext = wikitext[2:]
+			continue
+		}
+		link := wikitext[2 : end-2]
+		wikitext = wikitext[end:]
+		fn(link)
+		if strings.Contains(link, "[[") {
+			eachLink(link, fn)
+		}
+	}
+}
+
+// linkTargets returns the normalized titles the internal links of wikitext
+// point to, each once in order of their first appearance. Links to files and
+// categories aren't links in the text and are left out.
+func linkTargets(wikitext string) []string {
+	var targets []string
+	seen := make(map[string]bool)
+	eachLink(wikitext, func(link string) {
+		target, _, _ := strings.Cut(link, "|")
+		if isFileOrCategory(target) {
+			return
+		}
+		target, _, _ = strings.Cut(target, "#")
+		target = normalizeTitle(strings.TrimSpace(strings.TrimPrefix(decodeEntities(strings.TrimSpace(target)), ":")))
+		if target != "" && !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	})
+	return targets
+}
+
+// categoryLinks returns the categories wikitext declares, each once. Links
+// to a category page like [[:Category:Name]] don't make an article a member.
+func categoryLinks(wikitext string) []categoryLink {
+	var categories []categoryLink
+	seen := make(map[string]bool)
+	eachLink(wikitext, func(link string) {
+		target, sortKey, _ := strings.Cut(link, "|")
+		title, ok := cutNamespace(target, "Category")
+		if !ok {
+			return
+		}
+		name := normalizeTitle(strings.TrimSpace(decodeEntities(title)))
+		if name != "" && !seen[name] {
+			seen[name] = true
+			categories = append(categories, categoryLink{name, strings.TrimSpace(sortKey)})
+		}
+	})
+	return categories
+}
+
+// categoryNames returns the names of the categories wikitext declares
+func categoryNames(wikitext string) []string {
+	names := []string{}
+	for _, category := range categoryLinks(wikitext) {
+		names = append(names, category.Name)
+	}
+	return names
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestCategoryLinks(t *testing.T) {
+	wikitext := "Text [[Category:Physicists]] [[Category : Mathematicians|Turing]]\n" +
+		"[[category_:physicists]] [[:Category:Linked only]] [[Categorical]] [[Category: ]]"
+	want := []categoryLink{{"Physicists", ""}, {"Mathematicians", "Turing"}}
+	if got := categoryLinks(wikitext); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLinkTargets(t *testing.T) {
+	wikitext := "[[Alan Turing|Turing]] met [[alan_Turing]] and [[Alan Turing#Career|him]] at [[Bletchley Park]].\n" +
+		"[[:Mathematics]] [[Category:Mathematicians]] [[File:Turing.jpg|thumb|[[Bletchley Park|Park]]]] [[Image:X.png]] " +
+		"[[ Computer science | CS ]] [[#Career]] [[Tom &amp; Jerry]]"
+	want := []string{"Alan Turing", "Bletchley Park", "Mathematics", "Computer science", "Tom & Jerry"}
+	if got := linkTargets(wikitext); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := linkTargets("No links here"); got != nil {
+		t.Errorf("without links: got %q", got)
+	}
+}
+
+func TestServeLinksJSON(t *testing.T) {
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	wikiRoutes(mux, "", h)
+	rec := get(mux, "/api/links/Alan_Turing")
+	var got linksJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+	want := linksJSON{"Alan Turing", []string{"Computer science", "Mathematics", "Mathematician", "London", "Maida Vale", "Bletchley Park", "Cryptanalysis"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if rec := get(mux, "/api/links/Missing_article"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing article: got %d", rec.Code)
+	}
+}
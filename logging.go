@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelInfo
+	levelDebug
+)
+
+// currentLogLevel is set once by the -loglevel flag before serving
+var currentLogLevel = levelInfo
+
+func parseLogLevel(name string) (logLevel, error) {
+	switch name {
+	case "error":
+		return levelError, nil
+	case "info":
+		return levelInfo, nil
+	case "debug":
+		return levelDebug, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+}
+
+// logError logs a failure, which happens regardless of the log level
+func logError(v ...interface{}) {
+	log.Println(v...)
+}
+
+// logInfo logs what happens to the server, like loading indexes
+func logInfo(v ...interface{}) {
+	if currentLogLevel >= levelInfo {
+		log.Println(v...)
+	}
+}
+
+// logDebug logs the requests served and details of handling them
+func logDebug(v ...interface{}) {
+	if currentLogLevel >= levelDebug {
+		log.Println(v...)
+	}
+}
+
+type requestInfoKey struct{}
+
+// requestInfo carries what the handlers found out about a request to the
+// logging middleware
+type requestInfo struct {
+	title string
+}
+
+// noteTitle records the resolved article title of r for the request log
+func noteTitle(r *http.Request, title string) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.title = title
+	}
+}
+
+// statusWriter remembers the status code and the number of bytes written
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+type requestLogJSON struct {
+	Time     string  `json:"time"`
+	Method   string  `json:"method"`
+	Path     string  `json:"path"`
+	Title    string  `json:"title,omitempty"`
+	Status   int     `json:"status"`
+	Bytes    int     `json:"bytes"`
+	Duration float64 `json:"durationMs"`
+}
+
+var jsonLogger = log.New(os.Stderr, "", 0)
+
+// logHandler logs one line per request with its status, size, duration and
+// title, as a JSON object if asJSON is set. Lines are only logged at the debug
+// level as they add up quickly in production.
+func logHandler(asJSON bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		if currentLogLevel < levelDebug {
+			return
+		}
+		duration := time.Since(start)
+		if !asJSON {
+			log.Printf("%s %s %d %dB %v title=%q", r.Method, r.URL.RequestURI(), sw.status, sw.bytes, duration, info.title)
+			return
+		}
+		line, err := json.Marshal(requestLogJSON{
+			Time:     start.UTC().Format(time.RFC3339Nano),
+			Method:   r.Method,
+			Path:     r.URL.RequestURI(),
+			Title:    info.title,
+			Status:   sw.status,
+			Bytes:    sw.bytes,
+			Duration: float64(duration.Microseconds()) / 1000,
+		})
+		if err != nil {
+			logError(err)
+			return
+		}
+		jsonLogger.Println(string(line))
+	})
+}
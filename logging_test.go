@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRequestLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	jsonLogger.SetOutput(&buf)
+	defer func(level logLevel) {
+		log.SetOutput(os.Stderr)
+		jsonLogger.SetOutput(os.Stderr)
+		currentLogLevel = level
+	}(currentLogLevel)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		noteTitle(r, "Alan Turing")
+		logError("failure")
+		w.Write([]byte("body"))
+	})
+	tests := []struct {
+		level   logLevel
+		asJSON  bool
+		logged  bool
+		request string
+	}{
+		{levelError, false, false, `GET /wiki/Alan_Turing 200 4B`},
+		{levelInfo, false, false, `GET /wiki/Alan_Turing 200 4B`},
+		{levelDebug, false, true, `GET /wiki/Alan_Turing 200 4B`},
+		{levelDebug, false, true, `title="Alan Turing"`},
+		{levelInfo, true, false, `"title":"Alan Turing"`},
+		{levelDebug, true, true, `"title":"Alan Turing"`},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		currentLogLevel = tt.level
+		get(logHandler(tt.asJSON, handler), "/wiki/Alan_Turing")
+		out := buf.String()
+		if !strings.Contains(out, "failure") {
+			t.Errorf("level %d: error not logged in %q", tt.level, out)
+		}
+		if strings.Contains(out, tt.request) != tt.logged {
+			t.Errorf("level %d, JSON %v: got %q, want %q logged %v", tt.level, tt.asJSON, out, tt.request, tt.logged)
+		}
+	}
+}
@@ -1,20 +1,39 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"compress/bzip2"
-	"encoding/xml"
+	"context"
 	"flag"
-	"io"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
-var indexFilePath, contentFilePath string
+var (
+	indexFilePath, contentFilePath, cacheFilePath string
+	lookupTitle, namespaceList, indexKind         string
+	rendererName, aliasesPath, warmPath, matchBy  string
+	blocklistPath, adminToken                     string
+	corsOrigins, searchIndexPath, logLevelName    string
+	backlinksPath, staticDir, buildIndexPath      string
+	buildSearch, buildBacklinks, trustProxy       bool
+	validateOffsets, singleStream, enablePprof    bool
+	articleCacheSize, verifySamples, maxBytes     int
+	verifyThreshold, rateLimit                    float64
+	rateBurst, maxConcurrent                      int
+	chunkCacheBytes                               int64
+	extraWikis                                    wikiConfigs
+
+	listenAddr                                                string
+	readHeaderTimeout, readTimeout, writeTimeout, idleTimeout time.Duration
+	shutdownTimeout, extractTimeout                           time.Duration
+	logJSON                                                   bool
+)
 
 func init() {
 	const (
@@ -24,157 +43,300 @@ func init() {
 
 	flag.StringVar(&indexFilePath, "i", defaultIndexFile, "the index file to use")
 	flag.StringVar(&contentFilePath, "d", defaultContentFile, "the content file to use")
+	flag.Var(&extraWikis, "wiki", "serve the wiki lang=indexpath,contentpath below /wiki/lang/, may be repeated and replaces -i and -d")
+	flag.BoolVar(&singleStream, "singlestream", false, "index the pages of a single stream -d dump without multistream index on start instead of reading -i, compressed dumps only up to 256 MB")
+	flag.StringVar(&cacheFilePath, "cache", "", "cache the parsed index in this file to speed up later starts")
+	flag.StringVar(&warmPath, "warm", "", "extract the titles in this file, one per line, into the article cache of the first wiki on start")
+	flag.IntVar(&articleCacheSize, "cachesize", 1000, "number of extracted articles to keep in memory, 0 disables caching")
+	flag.Int64Var(&chunkCacheBytes, "chunkcache", 64<<20, "bytes of decompressed streams to keep in memory, 0 disables the chunk cache")
+	flag.StringVar(&indexKind, "index", "map", "keep the index in a \"map\" for fast lookups or a \"sorted\" slice to save memory")
+	flag.StringVar(&rendererName, "renderer", "html", "render articles below /wiki/ as \"html\", \"text\" or \"raw\" wikitext")
+	flag.StringVar(&aliasesPath, "aliases", "", "redirect the names in this file of alias<TAB>title lines to their articles in the first wiki")
+	flag.IntVar(&maxBytes, "maxbytes", 0, "truncate the wikitext of articles beyond this many bytes, 0 serves them whole")
+	flag.StringVar(&matchBy, "matchby", "id", "find articles in their stream by page \"id\" or by \"title\" with the id deciding between equal titles")
+	flag.StringVar(&blocklistPath, "blocklist", "", "hide the titles in this file, one per line, as if they weren't in the index")
+	flag.BoolVar(&validateOffsets, "validateoffsets", false, "drop titles from the index whose offsets lie beyond the end of the content file")
+	flag.StringVar(&namespaceList, "namespaces", "0", "comma separated list of namespace numbers to serve or \"all\"")
+	flag.StringVar(&searchIndexPath, "searchindex", "", "load the full text search index used by /search from this file")
+	flag.BoolVar(&buildSearch, "buildsearch", false, "build the -searchindex in the background if it is missing or outdated")
+	flag.StringVar(&backlinksPath, "backlinks", "", "load the links and categories used by /api/backlinks/ and /api/category/ from this file")
+	flag.BoolVar(&buildBacklinks, "buildbacklinks", false, "build the -backlinks in the background if they are missing or outdated")
+	flag.StringVar(&staticDir, "static", "static", "serve the web interface from this directory, a minimal start page is served if it doesn't exist")
+	flag.StringVar(&adminToken, "admintoken", "", "require this bearer token for /admin/ requests, open to everyone without it")
+	flag.BoolVar(&enablePprof, "pprof", false, "serve the profiling endpoints of net/http/pprof below /debug/pprof/, behind -admintoken if it is set")
+	flag.StringVar(&corsOrigins, "cors", "", "comma separated list of origins allowed to use the JSON API or \"*\" for all")
+	flag.Float64Var(&rateLimit, "ratelimit", 0, "requests per second allowed for each client IP, 0 disables rate limiting")
+	flag.IntVar(&rateBurst, "rateburst", 20, "requests a client IP may send at once before -ratelimit applies")
+	flag.BoolVar(&trustProxy, "trustproxy", false, "take client IPs from X-Forwarded-For as set by a proxy in front of the server")
+	flag.StringVar(&listenAddr, "addr", ":8080", "the address to listen on, or unix:/path/to/socket for a Unix domain socket")
+	flag.DurationVar(&readHeaderTimeout, "readheadertimeout", 10*time.Second, "maximum time to read request headers")
+	flag.DurationVar(&readTimeout, "readtimeout", 30*time.Second, "maximum time to read a whole request")
+	flag.DurationVar(&writeTimeout, "writetimeout", 60*time.Second, "maximum time to write a response")
+	flag.DurationVar(&idleTimeout, "idletimeout", 120*time.Second, "maximum time to keep idle connections open")
+	flag.IntVar(&maxConcurrent, "maxconcurrent", 0, "number of articles extracted at once, others wait for up to 5s and then get 503, 0 disables the limit")
+	flag.DurationVar(&extractTimeout, "extracttimeout", 10*time.Second, "maximum time to extract an article, 0 disables the limit")
+	flag.DurationVar(&shutdownTimeout, "shutdowntimeout", 30*time.Second, "maximum time to wait for active requests on shutdown")
+	flag.StringVar(&logLevelName, "loglevel", "info", "log only \"error\"s, also \"info\" about the server or \"debug\" requests and details")
+	flag.BoolVar(&logJSON, "logjson", false, "log requests as JSON objects")
+	flag.IntVar(&verifySamples, "verify", 0, "extract this many random titles to check the index against the content file and exit")
+	flag.Float64Var(&verifyThreshold, "verifythreshold", 0.01, "fraction of failed extractions above which -verify exits with an error")
+	flag.StringVar(&buildIndexPath, "buildindex", "", "reconstruct the index of the -d dump into this file and exit, for when the index file is lost")
+	flag.StringVar(&lookupTitle, "lookup", "", "print the article with this title and exit instead of starting the server")
 }
 
-type OffsetAndId struct {
-	Offset int64
-	Id     uint64
-}
-
-func readBzip2StreamOffsetAndId(indexFile *os.File) (map[string]OffsetAndId, error) {
-	indexFile.Seek(0, 0)
-	offsetMap := make(map[string]OffsetAndId)
-	indexStream := bzip2.NewReader(indexFile)
-	indexScanner := bufio.NewScanner(indexStream)
-	for indexScanner.Scan() {
-		splits := strings.SplitN(indexScanner.Text(), ":", 3)
-		offStr, idStr, currTitle := splits[0], splits[1], splits[2]
-		offset, err := strconv.ParseInt(offStr, 10, 64)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
-		id, err := strconv.ParseUint(idStr, 10, 64)
-		if err != nil {
-			log.Println(err)
-			continue
+// serve serves wikiHandler on all routes and each of langHandlers below
+// /wiki/<lang>/ for articles and below /<lang>/ for the other routes.
+func serve(wikiHandler *TinyWikiHandler, langHandlers map[string]*TinyWikiHandler) error {
+	http.Handle("/wiki/", http.StripPrefix("/wiki/", wikiHandler))
+	wikiRoutes(http.DefaultServeMux, "", wikiHandler)
+	for lang, langHandler := range langHandlers {
+		prefix := "/wiki/" + lang + "/"
+		http.Handle(prefix, http.StripPrefix(prefix, langHandler))
+		wikiRoutes(http.DefaultServeMux, "/"+lang, langHandler)
+	}
+	http.HandleFunc("/healthz", wikiHandler.ServeHealth)
+	http.HandleFunc("/metrics", serveMetrics)
+	http.Handle("/admin/stats", adminHandler(adminToken, statsHandler(wikiHandler, langHandlers)))
+	http.Handle("/", staticHandler(staticDir))
+	var allowedOrigins []string
+	for _, origin := range strings.Split(corsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowedOrigins = append(allowedOrigins, origin)
 		}
-		offsetMap[currTitle] = OffsetAndId{offset, id}
 	}
-	if err := indexScanner.Err(); err != nil {
-		return offsetMap, err
+	listener, err := listen(listenAddr)
+	if err != nil {
+		return err
+	}
+	var limiter *rateLimiter
+	if rateLimit > 0 {
+		limiter = newRateLimiter(rateLimit, rateBurst)
 	}
+	server := newServer(listenAddr, logHandler(logJSON, rateLimitHandler(limiter, trustProxy, gzipHandler(corsHandler(allowedOrigins, pprofHandler(enablePprof, adminToken, http.DefaultServeMux))))))
 
-	return offsetMap, nil
+	go reloadOnHangup(wikiHandler, langHandlers)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	return runServer(server, listener, stop)
 }
 
-func extractArticleMediawiki(bz2MultiStreamPath string, offId OffsetAndId) (content string, err error) {
-	const (
-		OUTSIDE       = iota
-		IN_PAGE       = iota
-		IN_ID         = iota
-		IN_TEXT       = iota
-		FOUND_ID      = iota
-		IN_MATCH_TEXT = iota
-	)
-	bz2MultiStream, err := os.Open(bz2MultiStreamPath)
-	if err != nil {
-		return "", err
-	}
-	defer bz2MultiStream.Close()
-	bz2MultiStream.Seek(offId.Offset, 0)
-	contentStream := bzip2.NewReader(bz2MultiStream)
-	dexml := xml.NewDecoder(contentStream)
-
-	depth, pageDepth := 0, 0
-	var tempData bytes.Buffer
-	state := OUTSIDE
-	for {
-		tok, err := dexml.Token()
-		if err != nil && err != io.EOF {
-			log.Fatal(err)
-		}
-		switch tok := tok.(type) {
-		case xml.StartElement:
-			depth += 1
-			switch {
-			case tok.Name.Local == "page":
-				pageDepth = depth
-				state = IN_PAGE
-			case tok.Name.Local == "id" && state != FOUND_ID:
-				state = IN_ID
-			case tok.Name.Local == "text":
-				if state == FOUND_ID {
-					state = IN_MATCH_TEXT
-				} else {
-					state = IN_TEXT
-				}
-			}
-		case xml.EndElement:
-			depth -= 1
-			switch {
-			case tok.Name.Local == "page":
-				state = OUTSIDE
-			case tok.Name.Local == "id" && state != FOUND_ID:
-				state = IN_PAGE
-				// Does this id belong to the latest page element
-				if depth != pageDepth {
-					tempData.Reset()
-					continue
-				}
-				currId, err := strconv.ParseUint(tempData.String(), 10, 64)
-				if err != nil {
-					log.Println(err)
-				} else if currId == offId.Id {
-					state = FOUND_ID
-				}
-				tempData.Reset()
-			case tok.Name.Local == "text":
-				if state == IN_MATCH_TEXT {
-					return tempData.String(), nil
-				}
-				state = IN_PAGE
-			}
-		case xml.CharData:
-			if state == IN_ID || state == IN_MATCH_TEXT {
-				tempData.Write(tok)
-			}
-		}
+// runServer serves on listener until it fails or a signal arrives on stop,
+// then it waits up to shutdownTimeout for active requests to finish.
+func runServer(server *http.Server, listener net.Listener, stop <-chan os.Signal) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-stop:
+		logInfo("Received", sig, "shutting down")
 	}
-	return content, err
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		return err
+	}
+	logInfo("Shutdown complete")
+	return nil
 }
 
-type TinyWikiHandler struct {
-	offsetMap       map[string]OffsetAndId
-	contentFilePath string
+// wikiRoutes registers the routes serving h other than its articles on mux
+// below prefix, which is empty for the default wiki.
+func wikiRoutes(mux *http.ServeMux, prefix string, h *TinyWikiHandler) {
+	handle := func(pattern string, handler http.HandlerFunc) {
+		if strings.HasSuffix(pattern, "/") {
+			mux.Handle(prefix+pattern, http.StripPrefix(prefix+pattern, handler))
+			return
+		}
+		mux.Handle(prefix+pattern, handler)
+	}
+	handle("/text/", h.ServeText)
+	handle("/random", h.ServeRandom)
+	handle("/search", h.ServeSearch)
+	handle("/api/search", h.ServeSearchJSON)
+	handle("/api/search/title", h.ServeTitleSearchJSON)
+	handle("/api/complete", h.ServeComplete)
+	handle("/api/suggest", h.ServeSuggest)
+	handle("/opensearch.xml", h.ServeOpenSearch)
+	handle("/api/titles", h.ServeTitles)
+	handle("/api/batch", h.ServeBatchJSON)
+	handle("/api/article/", h.ServeArticleJSON)
+	handle("/api/meta/", h.ServeMetaJSON)
+	handle("/api/byid/", h.ServeById)
+	handle("/api/infobox/", h.ServeInfoboxJSON)
+	handle("/api/links/", h.ServeLinksJSON)
+	handle("/api/backlinks/", h.ServeBacklinksJSON)
+	handle("/api/category/", h.ServeCategoryJSON)
+	handle("/api/exists/", h.ServeExistsJSON)
+	handle("/api/summary/", h.ServeSummaryJSON)
 }
 
-func NewTinyWikiHandler(offsetMap map[string]OffsetAndId, contentFilePath string) *TinyWikiHandler {
-	return &TinyWikiHandler{offsetMap, contentFilePath}
+// listen listens on the TCP address addr, which may be an IPv6 address like
+// [::1]:8080, or on the Unix domain socket at the path following "unix:". A
+// socket file left behind by a server that is gone is removed first. The
+// socket file is removed again once the listener is closed on shutdown.
+func listen(addr string) (net.Listener, error) {
+	socketPath, isUnix := strings.CutPrefix(addr, "unix:")
+	if !isUnix {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Stat(socketPath); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", socketPath)
+		}
+		logInfo("Removing stale socket", socketPath)
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", socketPath)
 }
 
-func (h *TinyWikiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	title := r.URL.Path
-	log.Println("Title:", title)
-	offsetAndId, ok := h.offsetMap[title]
-	if !ok {
-		log.Println("Couldn't find id for", title)
-		return
+// reloadOnHangup reloads the indexes of all wikis whenever SIGHUP is received
+func reloadOnHangup(wikiHandler *TinyWikiHandler, langHandlers map[string]*TinyWikiHandler) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		logInfo("Received SIGHUP, reloading")
+		handlers := map[*TinyWikiHandler]bool{wikiHandler: true}
+		for _, langHandler := range langHandlers {
+			handlers[langHandler] = true
+		}
+		for handler := range handlers {
+			if err := handler.reload(); err != nil {
+				logError("Couldn't reload", handler.indexPath, "keeping the old index:", err)
+				continue
+			}
+			logInfo("Reloaded", handler.indexPath, "with", handler.index().Len(), "titles")
+		}
+		if aliasesPath != "" {
+			if err := wikiHandler.loadAliases(aliasesPath); err != nil {
+				logError("Couldn't reload aliases, keeping the old ones:", err)
+			}
+		}
+		if searchIndexPath != "" {
+			wikiHandler.startSearch(searchIndexPath, buildSearch)
+		}
+		if backlinksPath != "" {
+			wikiHandler.startBacklinks(backlinksPath, buildBacklinks)
+		}
 	}
-	log.Println("Found offset:", offsetAndId.Offset, "and id:", offsetAndId.Id)
-	content, err := extractArticleMediawiki(h.contentFilePath, offsetAndId)
-	if err != nil {
-		log.Println(err)
-		return
+}
+
+// newServer creates the HTTP server with the timeouts set by the flags
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
 	}
-	io.WriteString(w, content)
 }
 
 func main() {
 	flag.Parse()
-	indexFile, err := os.Open(indexFilePath)
-	if err != nil {
+	if err := setFlagsFromEnv(flag.CommandLine); err != nil {
 		log.Fatal(err)
 	}
-	offsetMap, err := readBzip2StreamOffsetAndId(indexFile)
-	indexFile.Close()
+	var err error
+	if currentLogLevel, err = parseLogLevel(logLevelName); err != nil {
+		log.Fatal("Invalid -loglevel: ", err)
+	}
+	namespaces, err := parseNamespaces(namespaceList)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("Invalid -namespaces: ", err)
+	}
+	if maxConcurrent > 0 {
+		extractionSlots = make(chan struct{}, maxConcurrent)
 	}
 
-	wikiHandler := NewTinyWikiHandler(offsetMap, contentFilePath)
-	http.Handle("/wiki/", http.StripPrefix("/wiki/", wikiHandler))
-	http.Handle("/", http.FileServer(http.Dir("static")))
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	if buildIndexPath != "" {
+		pages, err := writeIndexFile(contentFilePath, buildIndexPath)
+		if err != nil {
+			log.Fatal("Couldn't build index: ", err)
+		}
+		logInfo("Wrote", pages, "pages to", buildIndexPath)
+		return
+	}
 
+	// With -wiki the first wiki given is also served on the default routes
+	var wikiHandler *TinyWikiHandler
+	langHandlers := make(map[string]*TinyWikiHandler)
+	for _, wiki := range extraWikis {
+		cachePath := cacheFilePath
+		if cachePath != "" {
+			cachePath += "." + wiki.lang
+		}
+		langHandler, err := loadWiki(wiki.indexPath, wiki.contentPath, cachePath, "/wiki/"+wiki.lang+"/", namespaces)
+		if err != nil {
+			log.Fatal(wiki.lang, ": ", err)
+		}
+		langHandlers[wiki.lang] = langHandler
+		if wikiHandler == nil {
+			wikiHandler = langHandler
+		}
+	}
+	if wikiHandler == nil {
+		wikiHandler, err = loadWiki(indexFilePath, contentFilePath, cacheFilePath, defaultLinkBase, namespaces)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if lookupTitle != "" {
+		if err := wikiHandler.printArticle(os.Stdout, lookupTitle); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if verifySamples > 0 {
+		failed := false
+		wikis := map[string]*TinyWikiHandler{wikiHandler.contentFilePath: wikiHandler}
+		for _, langHandler := range langHandlers {
+			wikis[langHandler.contentFilePath] = langHandler
+		}
+		for name, handler := range wikis {
+			report := handler.verify(verifySamples)
+			report.write(os.Stdout, name)
+			failed = failed || report.failureRate() > verifyThreshold
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+	if aliasesPath != "" {
+		if err := wikiHandler.loadAliases(aliasesPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if warmPath != "" {
+		titles, err := readTitleList(warmPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		logInfo("Warmed the cache with", wikiHandler.warm(titles), "of", len(titles), "titles")
+	}
+	if buildSearch && searchIndexPath == "" {
+		log.Fatal("-buildsearch needs -searchindex")
+	}
+	if searchIndexPath != "" {
+		wikiHandler.startSearch(searchIndexPath, buildSearch)
+	}
+	if buildBacklinks && backlinksPath == "" {
+		log.Fatal("-buildbacklinks needs -backlinks")
+	}
+	if backlinksPath != "" {
+		wikiHandler.startBacklinks(backlinksPath, buildBacklinks)
+	}
+	if err := serve(wikiHandler, langHandlers); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWikiRoutesPerLanguage(t *testing.T) {
+	en := newTestHandler(t)
+	de := loadTestWiki(t, "testdata/dewiki-index.txt.bz2", "testdata/dewiki.xml.bz2", "/wiki/de/")
+	mux := http.NewServeMux()
+	wikiRoutes(mux, "", en)
+	wikiRoutes(mux, "/en", en)
+	wikiRoutes(mux, "/de", de)
+
+	tests := []struct {
+		target string
+		status int
+		want   string
+	}{
+		{"/text/Alan_Turing", 200, "mathematician"},
+		{"/en/text/Alan_Turing", 200, "mathematician"},
+		{"/de/text/Alan_Turing", 200, "britischer Mathematiker"},
+		{"/de/api/exists/Berlin", 200, `"exists":true`},
+		{"/api/exists/Berlin", 404, `"exists":false`},
+		{"/de/api/article/Berlin", 200, "Hauptstadt"},
+		{"/de/api/suggest?q=Ber", 200, `["Ber",["Berlin"]]`},
+		{"/de/search?q=Berlin", 200, `href="/wiki/de/Berlin"`},
+		{"/de/opensearch.xml", 200, `template="http://example.com/de/search?q={searchTerms}"`},
+		{"/opensearch.xml", 200, `template="http://example.com/search?q={searchTerms}"`},
+	}
+	for _, tt := range tests {
+		rec := get(mux, tt.target)
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%s: got %d %q, want %d containing %q", tt.target, rec.Code, rec.Body.String(), tt.status, tt.want)
+		}
+	}
+	if rec := get(mux, "/de/random"); rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), "/wiki/de/") {
+		t.Errorf("/de/random: got %d to %q, want a redirect below /wiki/de/", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestNewServerTimeouts(t *testing.T) {
+	defer func(readHeader, read, write, idle time.Duration) {
+		readHeaderTimeout, readTimeout, writeTimeout, idleTimeout = readHeader, read, write, idle
+	}(readHeaderTimeout, readTimeout, writeTimeout, idleTimeout)
+	readHeaderTimeout, readTimeout, writeTimeout, idleTimeout = 50*time.Millisecond, 2*time.Second, 3*time.Second, 4*time.Second
+
+	server := newServer("127.0.0.1:0", http.NotFoundHandler())
+	if server.ReadHeaderTimeout != readHeaderTimeout || server.ReadTimeout != readTimeout || server.WriteTimeout != writeTimeout || server.IdleTimeout != idleTimeout {
+		t.Errorf("got timeouts %v, %v, %v and %v", server.ReadHeaderTimeout, server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+	}
+
+	// A client that never finishes its headers is cut off
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	io.Copy(io.Discard, conn)
+	if elapsed := time.Since(start); elapsed >= 5*time.Second {
+		t.Errorf("connection with incomplete headers still open after %v", elapsed)
+	}
+}
+
+func TestRunServerFinishesActiveRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server := newServer("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "finished")
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(server, listener, stop)
+	}()
+
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/")
+		if err != nil {
+			responses <- response{"", err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- response{string(body), err}
+	}()
+	<-started
+	stop <- os.Interrupt
+	select {
+	case err := <-done:
+		t.Fatalf("server stopped with a request active: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if resp := <-responses; resp.err != nil || resp.body != "finished" {
+		t.Errorf("active request: got %q, %v", resp.body, resp.err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("server still accepts connections after shutdown")
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	// Socket paths are limited to about 100 bytes, which t.TempDir may exceed
+	dir, err := os.MkdirTemp("", "tinypedia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "tinypedia.sock")
+
+	listener, err := listen("unix:" + socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "over the socket")
+	}))
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+	}}}
+	resp, err := client.Get("http://tinypedia/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "over the socket" {
+		t.Errorf("got %q, %v", body, err)
+	}
+	if _, err := listen("unix:" + socketPath); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("socket in use: got %v", err)
+	}
+	listener.Close()
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("socket file left after close: %v", err)
+	}
+
+	// A server that is gone without removing its socket leaves it stale
+	stale, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	listener, err = listen("unix:" + socketPath)
+	if err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	listener.Close()
+
+	// Other files are left alone
+	if err := os.WriteFile(socketPath, []byte("not a socket"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen("unix:" + socketPath); err == nil {
+		t.Error("listening in place of a regular file succeeded")
+	}
+	if content, err := os.ReadFile(socketPath); err != nil || string(content) != "not a socket" {
+		t.Errorf("regular file changed: %q, %v", content, err)
+	}
+}
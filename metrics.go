@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// histogram is a cumulative histogram in the Prometheus sense
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets ...float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(upper, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+}
+
+// metrics collects the counters exposed at /metrics
+var metrics = struct {
+	requests, notFound, cacheHits, cacheMisses atomic.Uint64
+	chunkCacheHits, rateLimited, tooBusy       atomic.Uint64
+	extractionDuration                         *histogram
+}{
+	extractionDuration: newHistogram(0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5),
+}
+
+func observeExtraction(start time.Time) {
+	metrics.extractionDuration.observe(time.Since(start).Seconds())
+}
+
+func writeCounter(w io.Writer, name, help string, value uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+// serveMetrics writes all metrics in the Prometheus text exposition format
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeCounter(w, "tinypedia_requests_total", "Article requests received.", metrics.requests.Load())
+	writeCounter(w, "tinypedia_not_found_total", "Article requests answered with 404.", metrics.notFound.Load())
+	writeCounter(w, "tinypedia_cache_hits_total", "Articles served from the article cache.", metrics.cacheHits.Load())
+	writeCounter(w, "tinypedia_cache_misses_total", "Articles that had to be extracted from the dump.", metrics.cacheMisses.Load())
+	writeCounter(w, "tinypedia_chunk_cache_hits_total", "Extractions that reused a decompressed stream from the chunk cache.", metrics.chunkCacheHits.Load())
+	writeCounter(w, "tinypedia_rate_limited_total", "Requests answered with 429 because the client exceeded -ratelimit.", metrics.rateLimited.Load())
+	writeCounter(w, "tinypedia_too_busy_total", "Extractions given up on because -maxconcurrent others were running.", metrics.tooBusy.Load())
+	metrics.extractionDuration.write(w, "tinypedia_extraction_duration_seconds", "Time spent extracting articles from the dump.")
+}
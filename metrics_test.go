@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics returns the samples served at /metrics by name
+func scrapeMetrics(t *testing.T) map[string]float64 {
+	t.Helper()
+	rec := get(http.HandlerFunc(serveMetrics), "/metrics")
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, " ")
+		if !ok {
+			t.Fatalf("malformed sample %q", line)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("sample %q: %v", line, err)
+		}
+		samples[name] = v
+	}
+	return samples
+}
+
+func TestMetricsCountRequests(t *testing.T) {
+	routes := wikiRoute(newTestHandler(t))
+	before := scrapeMetrics(t)
+	for _, target := range []string{"/wiki/Alan_Turing", "/wiki/Alan_Turing?action=raw", "/wiki/Missing_article"} {
+		get(routes, target)
+	}
+	after := scrapeMetrics(t)
+	for name, want := range map[string]float64{
+		"tinypedia_requests_total":                                3,
+		"tinypedia_not_found_total":                               1,
+		"tinypedia_cache_misses_total":                            2,
+		"tinypedia_cache_hits_total":                              0,
+		"tinypedia_extraction_duration_seconds_count":             2,
+		`tinypedia_extraction_duration_seconds_bucket{le="+Inf"}`: 2,
+	} {
+		if got := after[name] - before[name]; got != want {
+			t.Errorf("%s: went up by %g, want %g", name, got, want)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram(0.1, 1)
+	for _, v := range []float64{0.05, 0.5, 2} {
+		h.observe(v)
+	}
+	var out strings.Builder
+	h.write(&out, "test_seconds", "Test.")
+	want := `# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 2.55
+test_seconds_count 3
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGzipHandler(t *testing.T) {
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	mux.Handle("/wiki/", wikiRoute(h))
+	wikiRoutes(mux, "", h)
+	handler := gzipHandler(mux)
+	for _, target := range []string{"/wiki/Alan_Turing", "/api/titles"} {
+		plain := get(handler, target)
+		if plain.Code != 200 || plain.Header().Get("Content-Encoding") != "" || plain.Header().Get("Vary") == "" {
+			t.Fatalf("%s without gzip: got %d with Content-Encoding %q and Vary %q", target, plain.Code, plain.Header().Get("Content-Encoding"), plain.Header().Get("Vary"))
+		}
+		if plain.Body.Len() < gzipMinSize {
+			t.Fatalf("%s: only %d bytes, too small to be compressed", target, plain.Body.Len())
+		}
+		compressed := get(handler, target, "Accept-Encoding", "gzip, deflate")
+		if compressed.Header().Get("Content-Encoding") != "gzip" || !hasValue(compressed.Header().Values("Vary"), "Accept-Encoding") {
+			t.Fatalf("%s with gzip: got Content-Encoding %q and Vary %q", target, compressed.Header().Get("Content-Encoding"), compressed.Header().Values("Vary"))
+		}
+		zr, err := gzip.NewReader(compressed.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, plain.Body.Bytes()) {
+			t.Errorf("%s: decompressed body differs from the uncompressed one", target)
+		}
+	}
+
+	small := get(handler, "/api/complete?prefix=Alan", "Accept-Encoding", "gzip")
+	if small.Code != 200 || small.Header().Get("Content-Encoding") != "" {
+		t.Errorf("small response: got %d with Content-Encoding %q", small.Code, small.Header().Get("Content-Encoding"))
+	}
+	if rec := get(handler, "/api/titles", "Accept-Encoding", "gzip;q=0"); rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("gzip refused: got Content-Encoding %q", rec.Header().Get("Content-Encoding"))
+	}
+}
+
+// hasValue reports whether one of the comma separated header values is value
+func hasValue(values []string, value string) bool {
+	for _, v := range values {
+		for _, part := range bytes.Split([]byte(v), []byte(",")) {
+			if string(bytes.TrimSpace(part)) == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestCORSHandler(t *testing.T) {
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	mux.Handle("/wiki/", wikiRoute(h))
+	wikiRoutes(mux, "", h)
+	request := func(handler http.Handler, method, target string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	listed := corsHandler([]string{"https://a.example"}, mux)
+
+	preflight := request(listed, http.MethodOptions, "/api/article/Alan_Turing",
+		"Origin", "https://a.example", "Access-Control-Request-Method", "GET", "Access-Control-Request-Headers", "X-Requested-With")
+	want := map[string]string{
+		"Access-Control-Allow-Origin":  "https://a.example",
+		"Access-Control-Allow-Methods": "GET, HEAD, POST",
+		"Access-Control-Allow-Headers": "X-Requested-With",
+		"Access-Control-Max-Age":       "86400",
+		"Vary":                         "Origin",
+	}
+	if preflight.Code != http.StatusNoContent || preflight.Body.Len() != 0 {
+		t.Errorf("preflight: got %d %q, want %d", preflight.Code, preflight.Body.String(), http.StatusNoContent)
+	}
+	for name, value := range want {
+		if got := preflight.Header().Get(name); got != value {
+			t.Errorf("preflight: got %s %q, want %q", name, got, value)
+		}
+	}
+	other := request(listed, http.MethodOptions, "/api/article/Alan_Turing", "Origin", "https://b.example", "Access-Control-Request-Method", "GET")
+	if other.Code == http.StatusNoContent || other.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("preflight of another origin: got %d allowing %q", other.Code, other.Header().Get("Access-Control-Allow-Methods"))
+	}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		method  string
+		target  string
+		origin  string
+		status  int
+		allowed string
+	}{
+		{"listed origin", listed, http.MethodGet, "/api/article/Alan_Turing", "https://a.example", http.StatusOK, "https://a.example"},
+		{"other origin", listed, http.MethodGet, "/api/article/Alan_Turing", "https://b.example", http.StatusOK, ""},
+		{"HTML route", listed, http.MethodGet, "/wiki/Alan_Turing", "https://a.example", http.StatusOK, ""},
+		{"any origin", corsHandler([]string{"*"}, mux), http.MethodGet, "/api/article/Alan_Turing", "https://b.example", http.StatusOK, "*"},
+		{"no origins", corsHandler(nil, mux), http.MethodGet, "/api/article/Alan_Turing", "https://a.example", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		rec := request(tt.handler, tt.method, tt.target, "Origin", tt.origin, "Access-Control-Request-Method", "GET")
+		if rec.Code != tt.status || rec.Header().Get("Access-Control-Allow-Origin") != tt.allowed {
+			t.Errorf("%s: got %d allowing %q, want %d allowing %q", tt.name, rec.Code, rec.Header().Get("Access-Control-Allow-Origin"), tt.status, tt.allowed)
+		}
+	}
+}
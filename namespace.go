@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// namespacePrefixes maps the title prefixes of the English Wikipedia's
+// namespaces to their numbers. Titles without a known prefix are in the main
+// namespace 0.
+var namespacePrefixes = map[string]int{
+	"Talk":                   1,
+	"User":                   2,
+	"User talk":              3,
+	"Wikipedia":              4,
+	"Wikipedia talk":         5,
+	"File":                   6,
+	"File talk":              7,
+	"MediaWiki":              8,
+	"MediaWiki talk":         9,
+	"Template":               10,
+	"Template talk":          11,
+	"Help":                   12,
+	"Help talk":              13,
+	"Category":               14,
+	"Category talk":          15,
+	"Portal":                 100,
+	"Portal talk":            101,
+	"Draft":                  118,
+	"Draft talk":             119,
+	"TimedText":              710,
+	"TimedText talk":         711,
+	"Module":                 828,
+	"Module talk":            829,
+	"Gadget":                 2300,
+	"Gadget talk":            2301,
+	"Gadget definition":      2302,
+	"Gadget definition talk": 2303,
+}
+
+// titleNamespace returns the number of the namespace title belongs to
+func titleNamespace(title string) int {
+	i := strings.Index(title, ":")
+	if i < 0 {
+		return 0
+	}
+	if ns, ok := namespacePrefixes[title[:i]]; ok {
+		return ns
+	}
+	return 0
+}
+
+// namespaceSet is the set of namespaces kept in the index, a nil set keeps
+// all of them.
+type namespaceSet map[int]bool
+
+// parseNamespaces parses a comma separated list of namespace numbers. The
+// empty string and "all" select every namespace.
+func parseNamespaces(list string) (namespaceSet, error) {
+	if list == "" || list == "all" {
+		return nil, nil
+	}
+	namespaces := make(namespaceSet)
+	for _, nsStr := range strings.Split(list, ",") {
+		ns, err := strconv.Atoi(strings.TrimSpace(nsStr))
+		if err != nil {
+			return nil, err
+		}
+		namespaces[ns] = true
+	}
+	return namespaces, nil
+}
+
+func (s namespaceSet) allows(title string) bool {
+	return s == nil || s[titleNamespace(title)]
+}
+
+// String returns the set in the format accepted by parseNamespaces
+func (s namespaceSet) String() string {
+	if s == nil {
+		return "all"
+	}
+	namespaces := make([]int, 0, len(s))
+	for ns := range s {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Ints(namespaces)
+	strs := make([]string, len(namespaces))
+	for i, ns := range namespaces {
+		strs[i] = strconv.Itoa(ns)
+	}
+	return strings.Join(strs, ",")
+}
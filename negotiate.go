@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// articleFormats are the representations of an article served below /wiki/
+// in the order of preference, together with their media types
+var articleFormats = []struct {
+	name, mediaType string
+}{
+	{"html", "text/html"},
+	{"json", "application/json"},
+	{"text", "text/plain"},
+}
+
+// articleFormat picks the representation of an article requested by r. The
+// format parameter takes precedence over the Accept header and HTML is
+// served if neither asks for anything we have. ok is false for an unknown
+// format parameter.
+func articleFormat(r *http.Request) (format string, ok bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		for _, f := range articleFormats {
+			if f.name == format {
+				return format, true
+			}
+		}
+		return "", false
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return "html", true
+	}
+	best, bestQ := "html", 0.0
+	for _, f := range articleFormats {
+		if q := acceptQuality(accept, f.mediaType); q > bestQ {
+			best, bestQ = f.name, q
+		}
+	}
+	return best, true
+}
+
+// acceptQuality returns the quality value the Accept header accept assigns
+// to mediaType. The most specific matching media range counts.
+func acceptQuality(accept, mediaType string) float64 {
+	q, specificity := 0.0, -1
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		var s int
+		switch name {
+		case mediaType:
+			s = 2
+		case mainType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		rangeQ := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if key == "q" {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					rangeQ = parsed
+				}
+			}
+		}
+		q, specificity = rangeQ, s
+	}
+	return q
+}
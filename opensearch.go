@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSuggestLimit = 10
+	openSearchType      = "application/opensearchdescription+xml"
+)
+
+// openSearchLink announces the OpenSearch description in the head of pages
+const openSearchLink = `<link rel="search" type="` + openSearchType + `" href="/opensearch.xml" title="Tinypedia">`
+
+// openSearchDescription lets browsers add the server as a search engine with
+// suggestions taken from /api/suggest. %s is the server's base URL followed
+// by the prefix of the wiki's routes.
+const openSearchDescription = `<?xml version="1.0" encoding="UTF-8"?>
+<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">
+<ShortName>Tinypedia</ShortName>
+<Description>Search Tinypedia</Description>
+<InputEncoding>UTF-8</InputEncoding>
+<Url type="text/html" method="get" template="%[1]s/search?q={searchTerms}"/>
+<Url type="application/x-suggestions+json" method="get" template="%[1]s/api/suggest?q={searchTerms}"/>
+</OpenSearchDescription>
+`
+
+// baseURL returns the scheme and host the request was sent to. Behind a TLS
+// terminating proxy the scheme is taken from X-Forwarded-Proto.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// ServeOpenSearch serves the OpenSearch description of the server with
+// absolute URLs as the specification requires them. They point to the routes
+// of the wiki the description was requested from.
+func (h *TinyWikiHandler) ServeOpenSearch(w http.ResponseWriter, r *http.Request) {
+	if !allowReadMethods(w, r) {
+		return
+	}
+	prefix := strings.TrimSuffix(r.URL.Path, "/opensearch.xml")
+	description := fmt.Sprintf(openSearchDescription, template.HTMLEscapeString(baseURL(r)+prefix))
+	writeBody(w, r, openSearchType, description)
+}
+
+// ServeSuggest serves titles starting with the q parameter in the OpenSearch
+// suggestions format [query, [titles...]] browsers use for autocompletion.
+func (h *TinyWikiHandler) ServeSuggest(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultSuggestLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			writeJSON(w, http.StatusBadRequest, errorJSON{"invalid limit"})
+			return
+		}
+		if limit > maxCompleteLimit {
+			limit = maxCompleteLimit
+		}
+	}
+	q := query.Get("q")
+	titles := completePrefix(h.index(), q, limit)
+	if titles == nil {
+		titles = []string{}
+	}
+	writeJSON(w, http.StatusOK, []interface{}{q, titles})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestServeOpenSearch(t *testing.T) {
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	wikiRoutes(mux, "", h)
+	wikiRoutes(mux, "/de", h)
+	tests := []struct {
+		target, proto, base string
+	}{
+		{"/opensearch.xml", "", "http://example.com"},
+		{"/opensearch.xml", "https", "https://example.com"},
+		{"/de/opensearch.xml", "", "http://example.com/de"},
+	}
+	for _, tt := range tests {
+		rec := get(mux, tt.target, "X-Forwarded-Proto", tt.proto)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != openSearchType {
+			t.Fatalf("%s: got %d %q", tt.target, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		var description struct {
+			XMLName   xml.Name `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+			ShortName string
+			Urls      []struct {
+				Type     string `xml:"type,attr"`
+				Template string `xml:"template,attr"`
+			} `xml:"Url"`
+		}
+		if err := xml.Unmarshal(rec.Body.Bytes(), &description); err != nil {
+			t.Fatalf("%s: %v in %q", tt.target, err, rec.Body.String())
+		}
+		want := map[string]string{
+			"text/html":                      tt.base + "/search?q={searchTerms}",
+			"application/x-suggestions+json": tt.base + "/api/suggest?q={searchTerms}",
+		}
+		if description.ShortName != "Tinypedia" || len(description.Urls) != len(want) {
+			t.Errorf("%s: got %+v", tt.target, description)
+		}
+		for _, url := range description.Urls {
+			if url.Template != want[url.Type] {
+				t.Errorf("%s: got %s template %q, want %q", tt.target, url.Type, url.Template, want[url.Type])
+			}
+		}
+	}
+}
+
+func TestServeSuggest(t *testing.T) {
+	mux := http.NewServeMux()
+	wikiRoutes(mux, "", newTestHandler(t))
+	var sample01 []string
+	for i := 10; i < 20; i++ {
+		sample01 = append(sample01, fmt.Sprintf("Sample %03d", i))
+	}
+	tests := []struct {
+		target, query string
+		titles        []string
+	}{
+		{"/api/suggest?q=alan", "alan", []string{"Alan Turing"}},
+		{"/api/suggest?q=Sample+01", "Sample 01", sample01},
+		{"/api/suggest?q=Sample_01&limit=2", "Sample_01", sample01[:2]},
+		{"/api/suggest?q=Zebra", "Zebra", []string{}},
+	}
+	for _, tt := range tests {
+		rec := get(mux, tt.target)
+		// The response is exactly an array of the query and an array of
+		// titles
+		var response []json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusOK || len(response) != 2 {
+			t.Fatalf("%s: got %d %q", tt.target, rec.Code, rec.Body.String())
+		}
+		var query string
+		var titles []string
+		if err := json.Unmarshal(response[0], &query); err != nil || query != tt.query {
+			t.Errorf("%s: got query %s, want %q", tt.target, response[0], tt.query)
+		}
+		if err := json.Unmarshal(response[1], &titles); err != nil || titles == nil || !slices.Equal(titles, tt.titles) {
+			t.Errorf("%s: got titles %s, want %q", tt.target, response[1], tt.titles)
+		}
+	}
+	if rec := get(mux, "/api/suggest?q=a&limit=0"); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid limit") {
+		t.Errorf("limit 0: got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	_ "net/http/pprof"
+	"strings"
+)
+
+// pprofPrefix is where net/http/pprof registers its handlers on
+// http.DefaultServeMux as soon as it is imported
+const pprofPrefix = "/debug/pprof/"
+
+// pprofHandler hides the profiling endpoints below pprofPrefix unless they
+// are enabled, and then requires the admin token for them like for the other
+// admin endpoints if one is set
+func pprofHandler(enabled bool, token string, next http.Handler) http.Handler {
+	profiles := adminHandler(token, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, pprofPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !enabled {
+			http.NotFound(w, r)
+			return
+		}
+		profiles.ServeHTTP(w, r)
+	})
+}
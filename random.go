@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// maxRandomAttempts bounds how often a new title is drawn when only real
+// articles are requested
+const maxRandomAttempts = 10
+
+// randomTitle draws a title of the non-empty index
+func (h *TinyWikiHandler) randomTitle(index titleIndex) string {
+	h.randomMu.Lock()
+	defer h.randomMu.Unlock()
+	return index.Title(h.random.Intn(index.Len()))
+}
+
+// isArticle reports whether title is neither a redirect nor a disambiguation
+// page
+func (h *TinyWikiHandler) isArticle(ctx context.Context, title string) bool {
+	_, _, content, err := h.lookup(ctx, title)
+	if err != nil {
+		logError(err)
+		return false
+	}
+	_, isRedirect := redirectTarget(content)
+	return !isRedirect && !isDisambiguation(content)
+}
+
+// ServeRandom redirects to a uniformly chosen title of the index. With the
+// articlesOnly parameter set redirects and disambiguation pages are skipped.
+func (h *TinyWikiHandler) ServeRandom(w http.ResponseWriter, r *http.Request) {
+	index := h.index()
+	if index.Len() == 0 {
+		http.Error(w, "index is empty", http.StatusNotFound)
+		return
+	}
+	title := h.randomTitle(index)
+	if r.URL.Query().Get("articlesOnly") == "1" {
+		for attempt := 1; attempt < maxRandomAttempts && !h.isArticle(r.Context(), title); attempt++ {
+			title = h.randomTitle(index)
+		}
+	}
+	http.Redirect(w, r, wikiURL(h.linkBase, title), http.StatusFound)
+}
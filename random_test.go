@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"testing"
+)
+
+func TestServeRandomSeeded(t *testing.T) {
+	h := newTestHandler(t)
+	h.random = rand.New(rand.NewSource(1))
+	want := rand.New(rand.NewSource(1))
+	index := h.index()
+	for i := 0; i < 10; i++ {
+		rec := get(http.HandlerFunc(h.ServeRandom), "/random")
+		location := wikiURL(h.linkBase, index.Title(want.Intn(index.Len())))
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != location {
+			t.Fatalf("draw %d: got %d to %q, want a redirect to %q", i, rec.Code, rec.Header().Get("Location"), location)
+		}
+	}
+}
+
+func TestServeRandomArticlesOnly(t *testing.T) {
+	h := newTestHandler(t)
+	h.random = rand.New(rand.NewSource(1))
+	skipped := map[string]bool{"/wiki/NYC": true, "/wiki/Big_Apple": true, "/wiki/Loop": true, "/wiki/Mercury": true}
+	for i := 0; i < 50; i++ {
+		rec := get(http.HandlerFunc(h.ServeRandom), "/random?articlesOnly=1")
+		if location := rec.Header().Get("Location"); rec.Code != http.StatusFound || skipped[location] {
+			t.Fatalf("draw %d: got %d to %q, want a redirect to an article", i, rec.Code, location)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitPruneInterval is how often buckets of clients that have been
+// quiet long enough to be full again are dropped
+const rateLimitPruneInterval = time.Minute
+
+// tokenBucket holds the tokens a client had left at last
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter hands each client a token bucket refilled with rate tokens per
+// second and holding up to burst tokens. Every request takes one token.
+type rateLimiter struct {
+	rate, burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(max(burst, 1)), buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from the bucket of client. If there is none left it
+// returns how long the client has to wait for the next one.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastPrune) > rateLimitPruneInterval {
+		l.prune(now)
+	}
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{l.burst, now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// prune drops the buckets that would be full by now, their clients start
+// over with a new full bucket anyway
+func (l *rateLimiter) prune(now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastPrune = now
+}
+
+// clientIP returns the address of the client that sent r. With trustProxy
+// the last address in X-Forwarded-For is used, which is the one the proxy in
+// front of the server saw, as earlier ones are easily forged.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if forwarded := r.Header.Values("X-Forwarded-For"); trustProxy && len(forwarded) > 0 {
+		hops := strings.Split(forwarded[len(forwarded)-1], ",")
+		if last := strings.TrimSpace(hops[len(hops)-1]); last != "" {
+			return last
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitHandler answers requests of clients that ran out of tokens with
+// 429 Too Many Requests and a Retry-After header. A nil limiter lets every
+// request through.
+func rateLimitHandler(limiter *rateLimiter, trustProxy bool, next http.Handler) http.Handler {
+	if limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := limiter.allow(clientIP(r, trustProxy), time.Now())
+		if !ok {
+			metrics.rateLimited.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitPerClient(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	// A rate low enough that no token is refilled while the test runs
+	handler := rateLimitHandler(newRateLimiter(0.001, 2), false, ok)
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/wiki/Berlin", nil)
+		r.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := request("192.0.2.1:1234"); rec.Code != 200 {
+			t.Fatalf("request %d within the burst: got %d", i, rec.Code)
+		}
+	}
+	// Another port is still the same client
+	rec := request("192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request beyond the burst: got %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+	if rec := request("192.0.2.2:1234"); rec.Code != 200 {
+		t.Fatalf("other client: got %d", rec.Code)
+	}
+}
+
+func TestRateLimitRefill(t *testing.T) {
+	limiter := newRateLimiter(2, 1)
+	now := time.Now()
+	if ok, _ := limiter.allow("a", now); !ok {
+		t.Fatal("first request denied")
+	}
+	ok, wait := limiter.allow("a", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("second request: got %v, wait %v", ok, wait)
+	}
+	if ok, _ := limiter.allow("a", now.Add(500*time.Millisecond)); !ok {
+		t.Fatal("request after the refill denied")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Add("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+	if got := clientIP(r, false); got != "192.0.2.1" {
+		t.Errorf("untrusted proxy: got %q", got)
+	}
+	if got := clientIP(r, true); got != "198.51.100.7" {
+		t.Errorf("trusted proxy: got %q", got)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// readerTemplate is the document around an article in the reader view. It
+// has no navigation and brings its own stylesheet so a saved page stays
+// readable offline.
+var readerTemplate = template.Must(template.New("reader").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 38em; margin: 2em auto; padding: 0 1em; font: 1.1em/1.6 Georgia, serif; color: #222; }
+h1, h2, h3, h4, h5, h6 { line-height: 1.25; }
+a { color: inherit; }
+@media print { body { margin: 0; max-width: none; } a { text-decoration: none; } }
+</style>
+</head>
+<body>
+<h1>{{.Heading}}</h1>
+{{.Body}}</body>
+</html>
+`))
+
+// readerBoxRegexp matches the start of tables laid out as boxes beside or
+// below the prose
+var readerBoxRegexp = regexp.MustCompile(`(?i)^\{\|.*class\s*=\s*"?[^"]*\b(infobox|navbox|sidebar|vertical-navbox)`)
+
+// ReaderRenderer renders only the prose, headings and lists of an article
+// as an HTML fragment without a table of contents for the reader view.
+// Internal links point to articles below LinkBase.
+type ReaderRenderer struct {
+	LinkBase string
+}
+
+func (r ReaderRenderer) Render(wikitext string) ([]byte, string) {
+	return []byte(renderWikitext(readerWikitext(wikitext), r.LinkBase)), htmlContentType
+}
+
+// readerWikitext expands the templates of wikitext and drops the ones
+// without an expander, like infoboxes and navboxes, along with tables styled
+// as such boxes
+func readerWikitext(wikitext string) string {
+	lines := strings.Split(dropTemplates(expandTemplates(wikitext)), "\n")
+	var kept []string
+	for i := 0; i < len(lines); i++ {
+		if readerBoxRegexp.MatchString(strings.TrimSpace(lines[i])) {
+			i = tableEnd(lines, i)
+			continue
+		}
+		kept = append(kept, lines[i])
+	}
+	return strings.Join(kept, "\n")
+}
+
+// dropTemplates removes all {{...}} templates from wikitext
+func dropTemplates(wikitext string) string {
+	var b strings.Builder
+	templates := newDelimiterScanner("{{", "}}")
+	for {
+		start := strings.Index(wikitext, "{{")
+		if start < 0 {
+			break
+		}
+		b.WriteString(wikitext[:start])
+		wikitext = templates.skip(wikitext[start:])
+	}
+	b.WriteString(wikitext)
+	return b.String()
+}
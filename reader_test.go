@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReaderWikitext(t *testing.T) {
+	tests := []struct {
+		name, wikitext, want string
+	}{
+		{"infobox", "{{Infobox person\n| name = A\n| born = {{birth date|1912|6|23}}\n}}\nA was a person.", "\nA was a person."},
+		{"expanded template kept", "Called {{lang|fr|la ville}} by some.", "Called la ville by some."},
+		{"navbox table", "Text.\n{| class=\"navbox\"\n|-\n| [[A]] || [[B]]\n|}\nMore.", "Text.\nMore."},
+		{"infobox table", "{| class=\"infobox vcard\"\n! Name\n| A\n|}\nText.", "Text."},
+		{"other table kept", "{| class=\"wikitable\"\n| A\n|}", "{| class=\"wikitable\"\n| A\n|}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readerWikitext(tt.wikitext); got != tt.want {
+				t.Errorf("readerWikitext(%q) = %q, want %q", tt.wikitext, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderView(t *testing.T) {
+	rec := get(wikiRoute(newTestHandler(t)), "/wiki/Alan_Turing?view=reader")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != htmlContentType {
+		t.Fatalf("got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{"<h1>Alan Turing</h1>", "<b>Alan Mathison Turing</b> was an English", `<h2 id="Career">Career</h2>`, "<style>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in %q", want, body)
+		}
+	}
+	// The infobox with the only link to Mathematics is left out and so are
+	// the table of contents and the site's navigation
+	for _, omitted := range []string{"Infobox", "birth_date", "/wiki/Mathematics", `id="toc"`, "/tinypedia.css", `action="/search"`} {
+		if strings.Contains(body, omitted) {
+			t.Errorf("reader view contains %q: %q", omitted, body)
+		}
+	}
+}
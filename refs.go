@@ -0,0 +1,184 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	refNameRegexp   = regexp.MustCompile(`(?i)\bname\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s/>]+))`)
+	citeStartRegexp = regexp.MustCompile(`(?i)\{\{\s*cite\b`)
+	// referencesRegexp matches the <references/> tag marking where
+	// MediaWiki places the footnotes
+	referencesRegexp = regexp.MustCompile(`(?is)<references\b[^>]*/>|<references\b[^>]*>.*?</references>`)
+)
+
+// handleRefs applies the ?refs= mode to wikitext. With "strip", the default,
+// all <ref> tags are removed, with "collect" they are replaced by numbered
+// markers and listed as footnotes at the end. ok is false for an unknown
+// mode.
+func handleRefs(wikitext, mode string) (string, bool) {
+	wikitext = referencesRegexp.ReplaceAllString(wikitext, "")
+	switch mode {
+	case "", "strip":
+		return stripRefs(wikitext), true
+	case "collect":
+		text, notes := collectRefs(wikitext)
+		if len(notes) == 0 {
+			return text, true
+		}
+		var b strings.Builder
+		b.WriteString(strings.TrimRight(text, "\n"))
+		b.WriteString("\n\n== Footnotes ==\n")
+		for i, note := range notes {
+			b.WriteString("* [" + strconv.Itoa(i+1) + "] " + note + "\n")
+		}
+		return b.String(), true
+	default:
+		return "", false
+	}
+}
+
+// stripRefs removes all <ref> tags including their content
+func stripRefs(wikitext string) string {
+	var b strings.Builder
+	text := wikitext
+	for len(text) > 0 {
+		if isRefTag(text) {
+			text = skipRef(text)
+			continue
+		}
+		next := indexFold(text[1:], "<ref")
+		if next < 0 {
+			b.WriteString(text)
+			break
+		}
+		b.WriteString(text[:next+1])
+		text = text[next+1:]
+	}
+	return b.String()
+}
+
+// collectRefs replaces each <ref> tag with a marker like [1] and returns the
+// footnotes in order. A named ref gets a single footnote no matter how often
+// it is reused, its content may also be given by a later use.
+func collectRefs(wikitext string) (string, []string) {
+	var (
+		b      strings.Builder
+		notes  []string
+		byName = make(map[string]int)
+	)
+	text := wikitext
+	for len(text) > 0 {
+		if !isRefTag(text) {
+			next := indexFold(text[1:], "<ref")
+			if next < 0 {
+				b.WriteString(text)
+				break
+			}
+			b.WriteString(text[:next+1])
+			text = text[next+1:]
+			continue
+		}
+		tagEnd := strings.Index(text, ">")
+		if tagEnd < 0 {
+			b.WriteString(text)
+			break
+		}
+		tag := text[:tagEnd+1]
+		content, selfClosing := "", text[tagEnd-1] == '/'
+		rest := text[tagEnd+1:]
+		if !selfClosing {
+			closeStart := indexFold(rest, "</ref>")
+			if closeStart < 0 {
+				closeStart = len(rest)
+				rest += "</ref>"
+			}
+			content = strings.TrimSpace(expandCitations(rest[:closeStart]))
+			rest = rest[closeStart+len("</ref>"):]
+		}
+		text = rest
+
+		name := refName(tag)
+		n, seen := byName[name]
+		if name == "" || !seen {
+			notes = append(notes, content)
+			n = len(notes)
+			if name != "" {
+				byName[name] = n
+			}
+		} else if notes[n-1] == "" {
+			notes[n-1] = content
+		}
+		b.WriteString("[" + strconv.Itoa(n) + "]")
+	}
+	return b.String(), notes
+}
+
+// refName returns the name attribute of a <ref> tag
+func refName(tag string) string {
+	m := refNameRegexp.FindStringSubmatch(tag)
+	if m == nil {
+		return ""
+	}
+	return m[1] + m[2] + m[3]
+}
+
+// expandCitations replaces {{cite ...}} templates with a short plain
+// citation made of the author, title, work and date.
+func expandCitations(wikitext string) string {
+	var b strings.Builder
+	text := wikitext
+	for {
+		loc := citeStartRegexp.FindStringIndex(text)
+		if loc == nil {
+			b.WriteString(text)
+			return b.String()
+		}
+		b.WriteString(text[:loc[0]])
+		text = text[loc[0]:]
+		end := balancedEnd(text, "{{", "}}")
+		if end < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+		b.WriteString(citationText(splitTemplateParams(text[2 : end-2])[1:]))
+		text = text[end:]
+	}
+}
+
+func citationText(params []string) string {
+	fields := make(map[string]string)
+	for _, param := range params {
+		if key, value, ok := strings.Cut(param, "="); ok {
+			fields[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	author := fields["author"]
+	if author == "" && fields["last"] != "" {
+		author = fields["last"]
+		if fields["first"] != "" {
+			author += ", " + fields["first"]
+		}
+	}
+	title := fields["title"]
+	if title == "" {
+		title = fields["url"]
+	} else {
+		title = "''" + title + "''"
+	}
+	work := fields["work"]
+	for _, key := range []string{"website", "newspaper", "journal", "publisher"} {
+		if work == "" {
+			work = fields[key]
+		}
+	}
+	var parts []string
+	for _, part := range []string{author, title, work, fields["date"]} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ". ")
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCollectRefs(t *testing.T) {
+	tests := []struct {
+		name, wikitext, text string
+		notes                []string
+	}{
+		{"content", "A.<ref>Source one</ref> B.<REF> Source two </REF>", "A.[1] B.[2]", []string{"Source one", "Source two"}},
+		{"named reused", `A.<ref name="x">Source</ref> B.<ref name="x" /> C.<ref name=x/>`, "A.[1] B.[1] C.[1]", []string{"Source"}},
+		{"content given later", `A.<ref name='late'/> B.<ref>Other</ref> C.<ref name='late'>Late source</ref>`, "A.[1] B.[2] C.[1]", []string{"Late source", "Other"}},
+		{"self closing without name", "A.<ref/> B.", "A.[1] B.", []string{""}},
+		{"unclosed", "A.<ref>Dangling", "A.[1]", []string{"Dangling"}},
+		{"not a ref tag", "A <references/> <refx> B", "A <references/> <refx> B", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, notes := collectRefs(tt.wikitext)
+			if text != tt.text || !slices.Equal(notes, tt.notes) {
+				t.Errorf("collectRefs(%q) = %q, %q, want %q, %q", tt.wikitext, text, notes, tt.text, tt.notes)
+			}
+		})
+	}
+}
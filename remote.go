@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remoteBlockSize is the smallest number of bytes fetched by a single range
+// request. Decompressors read in small pieces so reading ahead saves round
+// trips.
+const remoteBlockSize = 1 << 20
+
+// remoteTimeout bounds a single request for a block or the size of a remote
+// dump, so a stalled server fails the read rather than holding it forever
+const remoteTimeout = 30 * time.Second
+
+var errRangesUnsupported = errors.New("server doesn't support range requests")
+
+// remoteClient sends the requests of remote dumps
+var remoteClient = &http.Client{Timeout: remoteTimeout}
+
+// contentFile gives random access to a dump, either a local file or one
+// served over HTTP.
+type contentFile interface {
+	io.ReaderAt
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// openContent opens the dump at path which may also be an http or https URL
+// or a directory of stream files
+func openContent(path string) (contentFile, error) {
+	return openContentContext(context.Background(), path)
+}
+
+// openContentContext is openContent for a dump read on behalf of ctx, the
+// requests of a remote dump are canceled along with it
+func openContentContext(ctx context.Context, path string) (contentFile, error) {
+	if isURL(path) {
+		return &remoteFile{url: path, ctx: ctx}, nil
+	}
+	if isSplitDump(path) {
+		return openSplitDump(path)
+	}
+	return os.Open(path)
+}
+
+// statContent returns the size and modification time of the dump at path
+func statContent(path string) (os.FileInfo, error) {
+	multiStream, err := openContent(path)
+	if err != nil {
+		return nil, err
+	}
+	defer multiStream.Close()
+	return multiStream.Stat()
+}
+
+// dumpSource opens the dump of a wiki for every extraction. The directory of
+// a split dump is listed once when the source is created, a reload creates a
+// new source to pick up changed stream files.
+type dumpSource struct {
+	path  string
+	split *splitDumpListing
+	// decompressed is set for a compressed single stream dump whose offsets
+	// are positions in the decompressed dump rather than in the file
+	decompressed bool
+}
+
+// newDumpSource prepares opening the dump at path, which may be anything
+// openContent accepts. singleStream tells that the dump is indexed by
+// singleStreamOffsets rather than a multistream index.
+func newDumpSource(path string, singleStream bool) (dumpSource, error) {
+	if !isSplitDump(path) {
+		return dumpSource{path: path, decompressed: singleStream && isCompressed(path)}, nil
+	}
+	listing, err := listSplitDump(path)
+	if err != nil {
+		return dumpSource{}, err
+	}
+	return dumpSource{path: path, split: listing}, nil
+}
+
+func (s dumpSource) open(ctx context.Context) (contentFile, error) {
+	if s.split != nil {
+		return s.split.open(), nil
+	}
+	return openContentContext(ctx, s.path)
+}
+
+// stat returns the size and modification time of the dump
+func (s dumpSource) stat() (os.FileInfo, error) {
+	multiStream, err := s.open(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer multiStream.Close()
+	return multiStream.Stat()
+}
+
+// fileBases returns the base offset of every stream file of a split dump,
+// keyed by file name, or nil if the dump isn't split
+func (s dumpSource) fileBases() map[string]int64 {
+	if s.split == nil {
+		return nil
+	}
+	return s.split.bases()
+}
+
+// remoteFile reads a file served over HTTP with range requests. The most
+// recently fetched block is kept to serve the small reads of decompressors.
+type remoteFile struct {
+	url string
+	ctx context.Context
+
+	mu         sync.Mutex
+	info       *remoteFileInfo
+	block      []byte
+	blockStart int64
+}
+
+func (f *remoteFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos < f.blockStart || pos >= f.blockStart+int64(len(f.block)) {
+			if err := f.fetch(pos, max(len(p)-n, remoteBlockSize)); err != nil {
+				return n, err
+			}
+			if len(f.block) == 0 {
+				return n, io.EOF
+			}
+		}
+		n += copy(p[n:], f.block[pos-f.blockStart:])
+	}
+	return n, nil
+}
+
+// fetch replaces the cached block by size bytes starting at off
+func (f *remoteFile) fetch(off int64, size int) error {
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(off, 10)+"-"+strconv.FormatInt(off+int64(size)-1, 10))
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		f.block, f.blockStart = nil, off
+		return nil
+	case http.StatusOK:
+		return errRangesUnsupported
+	default:
+		return fmt.Errorf("fetching %s: %s", f.url, resp.Status)
+	}
+	block, err := io.ReadAll(io.LimitReader(resp.Body, int64(size)))
+	if err != nil {
+		return err
+	}
+	f.block, f.blockStart = block, off
+	return nil
+}
+
+func (f *remoteFile) Stat() (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.info != nil {
+		return f.info, nil
+	}
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodHead, f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", f.url, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("fetching %s: size unknown", f.url)
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	f.info = &remoteFileInfo{path.Base(f.url), resp.ContentLength, modTime}
+	return f.info, nil
+}
+
+func (f *remoteFile) Close() error {
+	return nil
+}
+
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i *remoteFileInfo) Name() string       { return i.name }
+func (i *remoteFileInfo) Size() int64        { return i.size }
+func (i *remoteFileInfo) Mode() fs.FileMode  { return 0444 }
+func (i *remoteFileInfo) ModTime() time.Time { return i.modTime }
+func (i *remoteFileInfo) IsDir() bool        { return false }
+func (i *remoteFileInfo) Sys() interface{}   { return nil }
+
+// downloadIndex fetches the index at indexURL into a file in dir. The file
+// gets the server's modification time so the index cache notices new dumps.
+func downloadIndex(indexURL, dir string) (string, error) {
+	u, err := url.Parse(indexURL)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.Get(indexURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: %s", indexURL, resp.Status)
+	}
+	indexPath := filepath.Join(dir, path.Base(u.Path))
+	indexFile, err := os.Create(indexPath)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(indexFile, resp.Body); err != nil {
+		indexFile.Close()
+		return "", err
+	}
+	if err := indexFile.Close(); err != nil {
+		return "", err
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		if err := os.Chtimes(indexPath, modTime, modTime); err != nil {
+			return "", err
+		}
+	}
+	return indexPath, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRemoteFileReadsRanges(t *testing.T) {
+	dump, err := os.ReadFile(testContentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "multistream.xml.bz2", time.Time{}, bytes.NewReader(dump))
+	}))
+	defer server.Close()
+	f, err := openContentContext(context.Background(), server.URL+"/multistream.xml.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() != int64(len(dump)) {
+		t.Fatalf("got %v, %v, want size %d", info, err, len(dump))
+	}
+	p := make([]byte, 100)
+	if _, err := f.ReadAt(p, 150); err != nil || !bytes.Equal(p, dump[150:250]) {
+		t.Errorf("reading at 150: got %v, want the bytes of the local fixture", err)
+	}
+}
+
+func TestRemoteFileCanceledWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	f, err := openContentContext(ctx, server.URL+"/stalled.xml.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	start := time.Now()
+	_, err = f.ReadAt(make([]byte, 100), 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > remoteTimeout/2 {
+		t.Errorf("read of a stalled server returned after %v", elapsed)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var headingRegexp = regexp.MustCompile(`^(={1,6})\s*(.*?)\s*(={1,6})\s*$`)
+
+// renderWikitext converts the most common MediaWiki constructs into HTML.
+// Anything it doesn't understand is passed through as escaped text. Internal
+// links point to articles below linkBase and headings get the anchor ids of
+// buildTOC.
+func renderWikitext(wikitext, linkBase string) string {
+	var b strings.Builder
+	var paragraph []string
+	listDepth := 0
+	anchors := make(headingAnchors)
+
+	flushParagraph := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		rendered := renderInline(strings.Join(paragraph, "\n"), linkBase)
+		paragraph = paragraph[:0]
+		if strings.TrimSpace(rendered) == "" {
+			return
+		}
+		b.WriteString("<p>")
+		b.WriteString(rendered)
+		b.WriteString("</p>\n")
+	}
+	closeList := func(depth int) {
+		for listDepth > depth {
+			b.WriteString("</li></ul>\n")
+			listDepth--
+		}
+	}
+
+	lines := strings.Split(wikitext, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(line, "*") {
+			flushParagraph()
+			depth := len(line) - len(strings.TrimLeft(line, "*"))
+			closeList(depth)
+			if listDepth == depth {
+				b.WriteString("</li>\n<li>")
+			}
+			for listDepth < depth {
+				b.WriteString("<ul><li>")
+				listDepth++
+			}
+			b.WriteString(renderInline(strings.TrimSpace(line[depth:]), linkBase))
+			continue
+		}
+		closeList(0)
+
+		if strings.HasPrefix(trimmed, "{|") {
+			flushParagraph()
+			end := tableEnd(lines, i)
+			b.WriteString(renderTable(lines[i+1:end], linkBase))
+			i = end
+			continue
+		}
+
+		if m := headingRegexp.FindStringSubmatch(trimmed); m != nil {
+			flushParagraph()
+			level := len(m[1])
+			if len(m[3]) < level {
+				level = len(m[3])
+			}
+			tag := "h" + string(rune('0'+level))
+			b.WriteString("<" + tag + " id=\"" + template.HTMLEscapeString(anchors.next(headingText(m[2]))) + "\">")
+			b.WriteString(renderInline(m[2], linkBase))
+			b.WriteString("</" + tag + ">\n")
+			continue
+		}
+
+		if trimmed == "" {
+			flushParagraph()
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	closeList(0)
+	flushParagraph()
+	return b.String()
+}
+
+// renderInline handles bold, italic and internal links within a single block
+// of text, escaping everything else.
+func renderInline(text, linkBase string) string {
+	var b strings.Builder
+	var open []string
+
+	toggle := func(tag string) {
+		for i := len(open) - 1; i >= 0; i-- {
+			if open[i] != tag {
+				continue
+			}
+			// Close everything opened after tag, then reopen it so
+			// the tags stay properly nested.
+			reopen := open[i+1:]
+			for j := len(open) - 1; j >= i; j-- {
+				b.WriteString("</" + open[j] + ">")
+			}
+			for _, t := range reopen {
+				b.WriteString("<" + t + ">")
+			}
+			open = append(open[:i], reopen...)
+			return
+		}
+		b.WriteString("<" + tag + ">")
+		open = append(open, tag)
+	}
+
+	for len(text) > 0 {
+		switch {
+		case strings.HasPrefix(text, "'''''"):
+			toggle("b")
+			toggle("i")
+			text = text[5:]
+		case strings.HasPrefix(text, "'''"):
+			toggle("b")
+			text = text[3:]
+		case strings.HasPrefix(text, "''"):
+			toggle("i")
+			text = text[2:]
+		case strings.HasPrefix(text, "[["):
+			end := strings.Index(text, "]]")
+			if end < 0 {
+				b.WriteString(template.HTMLEscapeString(text[:2]))
+				text = text[2:]
+				continue
+			}
+			b.WriteString(renderLink(text[2:end], linkBase))
+			text = text[end+2:]
+		default:
+			next := strings.IndexAny(text[1:], "'[")
+			if next < 0 {
+				next = len(text) - 1
+			}
+			// Entities are decoded first so they are escaped
+			// exactly once
+			b.WriteString(template.HTMLEscapeString(decodeEntities(text[:next+1])))
+			text = text[next+1:]
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return dropEmptyTags(b.String())
+}
+
+// emptyTags are the elements toggled on and right off again by quotes with
+// nothing in between, like five quotes on their own
+var emptyTags = strings.NewReplacer("<i></i>", "", "<b></b>", "")
+
+// dropEmptyTags removes the empty elements of emptyTags from html, including
+// the ones only left empty once those nested in them are gone. Text is
+// escaped, so the tags can only stem from toggles.
+func dropEmptyTags(html string) string {
+	for {
+		trimmed := emptyTags.Replace(html)
+		if trimmed == html {
+			return html
+		}
+		html = trimmed
+	}
+}
+
+// defaultLinkBase is the path below which articles are served
+const defaultLinkBase = "/wiki/"
+
+// wikiURL returns the path under which the article title is served
+func wikiURL(linkBase, title string) string {
+	return linkBase + url.PathEscape(strings.Replace(title, " ", "_", -1))
+}
+
+// renderLink renders the inside of an internal [[...]] link. Category and
+// file links are metadata rather than links in the text so they are dropped.
+func renderLink(link, linkBase string) string {
+	target, display := link, strings.TrimPrefix(link, ":")
+	if i := strings.Index(link, "|"); i >= 0 {
+		target, display = link[:i], link[i+1:]
+		if strings.TrimSpace(display) == "" {
+			display = pipeTrick(target)
+		}
+	}
+	target = strings.TrimSpace(target)
+	if isFileOrCategory(target) {
+		return ""
+	}
+	target = decodeEntities(strings.TrimPrefix(target, ":"))
+
+	var href string
+	if i := strings.Index(target, "#"); i >= 0 {
+		href = "#" + url.PathEscape(strings.Replace(strings.TrimSpace(target[i+1:]), " ", "_", -1))
+		target = strings.TrimSpace(target[:i])
+	}
+	if target != "" {
+		href = wikiURL(linkBase, target) + href
+	}
+	return `<a href="` + template.HTMLEscapeString(href) + `">` +
+		template.HTMLEscapeString(decodeEntities(display)) + "</a>"
+}
+
+// pipeTrick computes the displayed text of a link with an empty display part
+// like [[Help:Pipe trick (example)|]] the way MediaWiki does.
+func pipeTrick(target string) string {
+	target = strings.TrimPrefix(strings.TrimSpace(target), ":")
+	if i := strings.Index(target, ":"); i >= 0 {
+		target = target[i+1:]
+	}
+	if i := strings.LastIndex(target, " ("); i >= 0 && strings.HasSuffix(target, ")") {
+		target = target[:i]
+	} else if i := strings.Index(target, ","); i >= 0 {
+		target = target[:i]
+	}
+	return strings.TrimSpace(target)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRenderInlineQuotes(t *testing.T) {
+	tests := []struct {
+		wikitext, want string
+	}{
+		{"''italic''", "<i>italic</i>"},
+		{"'''bold'''", "<b>bold</b>"},
+		{"'''''both'''''", "<b><i>both</i></b>"},
+		{"'''''", ""},
+		{"a ''''' b", "a <b><i> b</i></b>"},
+		{"''''''''''", ""},
+		{"''a'''''b'''", "<i>a</i><b>b</b>"},
+	}
+	for _, test := range tests {
+		if got := renderInline(test.wikitext, defaultLinkBase); got != test.want {
+			t.Errorf("renderInline(%q) = %q, want %q", test.wikitext, got, test.want)
+		}
+	}
+}
+
+func TestRenderWikitext(t *testing.T) {
+	tests := []struct {
+		name, wikitext, want string
+	}{
+		{"bold and italic", "'''bold''' and ''italic''", "<p><b>bold</b> and <i>italic</i></p>\n"},
+		{"link", "[[Foo Bar]]", `<p><a href="/wiki/Foo_Bar">Foo Bar</a></p>` + "\n"},
+		{"piped link", "[[Foo Bar|the foo]]", `<p><a href="/wiki/Foo_Bar">the foo</a></p>` + "\n"},
+		{"section link", "[[Foo#Bar baz|baz]]", `<p><a href="/wiki/Foo#Bar_baz">baz</a></p>` + "\n"},
+		{"category link", "[[Category:Foo]]", ""},
+		{"list", "* a\n* b\n** c", "<ul><li>a</li>\n<li>b<ul><li>c</li></ul>\n</li></ul>\n"},
+		{"heading", "== Head ==\ntext", `<h2 id="Head">Head</h2>` + "\n<p>text</p>\n"},
+		{"unbalanced heading", "=== Head ==", `<h2 id="Head">Head</h2>` + "\n"},
+		{"paragraphs", "one\n\ntwo", "<p>one</p>\n<p>two</p>\n"},
+		{"escaped", "<b>x</b> & y", "<p>&lt;b&gt;x&lt;/b&gt; &amp; y</p>\n"},
+		{"unknown template", "{{unknown}}", "<p>{{unknown}}</p>\n"},
+	}
+	for _, tt := range tests {
+		if got := renderWikitext(tt.wikitext, defaultLinkBase); got != tt.want {
+			t.Errorf("%s: renderWikitext(%q) = %q, want %q", tt.name, tt.wikitext, got, tt.want)
+		}
+	}
+}
+
+func TestArticleDocumentEscaping(t *testing.T) {
+	indexPath, contentPath := writeGzipDump(t, "Tom & Jerry", `[[Rock & Roll|R&amp;R <b>]], [[Say "hi"]], [[C++]], [[100%]] and [[A?b#x y]]`)
+	routes := wikiRoute(loadTestWiki(t, indexPath, contentPath, defaultLinkBase))
+	rec := get(routes, "/wiki/Tom_%26_Jerry")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+	for _, want := range []string{
+		"<title>Tom &amp; Jerry</title>",
+		"<h1>Tom &amp; Jerry</h1>",
+		`<a href="/wiki/Rock_&amp;_Roll">R&amp;R &lt;b&gt;</a>`,
+		`<a href="/wiki/Say_%22hi%22">Say &#34;hi&#34;</a>`,
+		`<a href="/wiki/C++">C++</a>`,
+		`<a href="/wiki/100%25">100%</a>`,
+		`<a href="/wiki/A%3Fb#x_y">A?b#x y</a>`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("got %q, want it to contain %q", rec.Body.String(), want)
+		}
+	}
+	raw := get(routes, "/wiki/Tom_%26_Jerry?raw=1")
+	if strings.Contains(raw.Body.String(), "<title>") || !strings.Contains(raw.Body.String(), `href="/wiki/Rock_&amp;_Roll"`) {
+		t.Errorf("raw: got %q, want only the rendered article", raw.Body.String())
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	htmlContentType = "text/html; charset=utf-8"
+	textContentType = "text/plain; charset=utf-8"
+)
+
+// Renderer turns the wikitext of an article into the body served for it
+type Renderer interface {
+	Render(wikitext string) (body []byte, contentType string)
+}
+
+// RawRenderer serves the wikitext as is
+type RawRenderer struct{}
+
+func (RawRenderer) Render(wikitext string) ([]byte, string) {
+	return []byte(wikitext), textContentType
+}
+
+// HTMLRenderer renders the wikitext as an HTML fragment with a table of
+// contents. Internal links point to articles below LinkBase.
+type HTMLRenderer struct {
+	LinkBase string
+}
+
+func (r HTMLRenderer) Render(wikitext string) ([]byte, string) {
+	wikitext = expandTemplates(wikitext)
+	return []byte(renderTOC(buildTOC(wikitext)) + renderWikitext(wikitext, r.LinkBase)), htmlContentType
+}
+
+// TextRenderer serves only the prose with all markup removed
+type TextRenderer struct{}
+
+func (TextRenderer) Render(wikitext string) ([]byte, string) {
+	return []byte(normalizeWhitespace(stripWikitext(wikitext))), textContentType
+}
+
+// newRenderer returns the renderer selected by the -renderer flag
+func newRenderer(name, linkBase string) (Renderer, error) {
+	switch name {
+	case "html":
+		return HTMLRenderer{linkBase}, nil
+	case "raw":
+		return RawRenderer{}, nil
+	case "text":
+		return TextRenderer{}, nil
+	}
+	return nil, fmt.Errorf("unknown renderer %q, expected html, raw or text", name)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRenderers(t *testing.T) {
+	const wikitext = "'''Alan''' studied [[Mathematics|maths]].\n== Career ==\nAt [[Bletchley Park]]."
+	tests := []struct {
+		renderer    Renderer
+		contentType string
+		contains    string
+		omits       string
+	}{
+		{RawRenderer{}, textContentType, "'''Alan''' studied [[Mathematics|maths]]", ""},
+		{HTMLRenderer{"/wiki/"}, htmlContentType, `<a href="/wiki/Mathematics">maths</a>`, "'''"},
+		{TextRenderer{}, textContentType, "Alan studied maths.", "[["},
+	}
+	for _, tt := range tests {
+		body, contentType := tt.renderer.Render(wikitext)
+		if contentType != tt.contentType {
+			t.Errorf("%T: got content type %q, want %q", tt.renderer, contentType, tt.contentType)
+		}
+		if !strings.Contains(string(body), tt.contains) || (tt.omits != "" && strings.Contains(string(body), tt.omits)) {
+			t.Errorf("%T: got %q, want it to contain %q but not %q", tt.renderer, body, tt.contains, tt.omits)
+		}
+	}
+}
+
+func TestRendererFlag(t *testing.T) {
+	defer func(name string) { rendererName = name }(rendererName)
+	for name, contentType := range map[string]string{"html": htmlContentType, "raw": textContentType, "text": textContentType} {
+		rendererName = name
+		rec := get(wikiRoute(newTestHandler(t)), "/wiki/Alan_Turing")
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != contentType {
+			t.Errorf("-renderer %s: got %d with %q, want %q", name, rec.Code, rec.Header().Get("Content-Type"), contentType)
+		}
+		if raw := strings.Contains(rec.Body.String(), "'''Alan Mathison Turing'''"); raw != (name == "raw") {
+			t.Errorf("-renderer %s: got %.80q", name, rec.Body.String())
+		}
+	}
+	if _, err := newRenderer("markdown", defaultLinkBase); err == nil {
+		t.Error("unknown renderer accepted")
+	}
+}
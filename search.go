@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"html/template"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var errStaleSearchIndex = errors.New("search index is stale")
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type searchDoc struct {
+	Title  string
+	Length uint32
+}
+
+type posting struct {
+	Doc  uint32
+	Freq uint32
+}
+
+// searchIndex is an inverted index over the plain text of all articles. Like
+// the index cache it remembers the size and modification time of the dump it
+// was built from.
+type searchIndex struct {
+	SourceSize    int64
+	SourceModTime time.Time
+	Docs          []searchDoc
+	Postings      map[string][]posting
+	TotalLength   uint64
+}
+
+type searchResult struct {
+	Title string
+	Score float64
+}
+
+// tokenize splits text into lower cased words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// scanArticles scans the whole dump at multiStreamPath and calls fn for every
+// page that is part of index. It returns the size and modification time of
+// the dump for the indexes built from it.
+func scanArticles(multiStreamPath string, index titleIndex, fn func(page *wikiPage)) (os.FileInfo, error) {
+	decompress, err := decompressorFor(multiStreamPath)
+	if err != nil {
+		return nil, err
+	}
+	multiStream, err := openContent(multiStreamPath)
+	if err != nil {
+		return nil, err
+	}
+	defer multiStream.Close()
+	info, err := multiStream.Stat()
+	if err != nil {
+		return nil, err
+	}
+	contentStream, err := decompress(bufio.NewReaderSize(io.NewSectionReader(multiStream, 0, info.Size()), remoteBlockSize))
+	if err != nil {
+		return nil, err
+	}
+	err = scanPages(contentStream, func(page *wikiPage) bool {
+		offsetAndId, ok := index.Lookup(page.Title)
+		return ok && offsetAndId.Id == page.Id
+	}, func(page *wikiPage) bool {
+		fn(page)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// buildSearchIndex indexes the stripped text of every article of the dump at
+// multiStreamPath that is part of index.
+func buildSearchIndex(multiStreamPath string, index titleIndex) (*searchIndex, error) {
+	search := &searchIndex{Postings: make(map[string][]posting)}
+	info, err := scanArticles(multiStreamPath, index, func(page *wikiPage) {
+		if _, ok := redirectTarget(page.Text); ok {
+			return
+		}
+		doc := uint32(len(search.Docs))
+		freqs := make(map[string]uint32)
+		terms := tokenize(stripWikitext(stripRefs(page.Text)))
+		for _, term := range terms {
+			freqs[term]++
+		}
+		for term, freq := range freqs {
+			search.Postings[term] = append(search.Postings[term], posting{doc, freq})
+		}
+		search.Docs = append(search.Docs, searchDoc{page.Title, uint32(len(terms))})
+		search.TotalLength += uint64(len(terms))
+	})
+	if err != nil {
+		return nil, err
+	}
+	search.SourceSize, search.SourceModTime = info.Size(), info.ModTime()
+	return search, nil
+}
+
+// query returns up to limit titles ranked by their BM25 score for the words
+// of q. Only articles containing all of the words are returned and only
+// those still in index, which leaves out blocked titles.
+func (s *searchIndex) query(q string, limit int, index titleIndex) []searchResult {
+	terms := tokenize(q)
+	if len(terms) == 0 || len(s.Docs) == 0 {
+		return nil
+	}
+	avgLength := float64(s.TotalLength) / float64(len(s.Docs))
+	scores := make(map[uint32]float64)
+	matches := make(map[uint32]int)
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := s.Postings[term]
+		idf := math.Log(1 + (float64(len(s.Docs))-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for _, p := range postings {
+			freq := float64(p.Freq)
+			norm := 1 - bm25B + bm25B*float64(s.Docs[p.Doc].Length)/avgLength
+			scores[p.Doc] += idf * freq * (bm25K1 + 1) / (freq + bm25K1*norm)
+			matches[p.Doc]++
+		}
+	}
+
+	results := make([]searchResult, 0, len(scores))
+	for doc, score := range scores {
+		if matches[doc] == len(seen) {
+			results = append(results, searchResult{s.Docs[doc].Title, score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Title < results[j].Title
+	})
+	kept := results[:0]
+	for _, result := range results {
+		if len(kept) == limit {
+			break
+		}
+		if _, ok := index.Lookup(result.Title); ok {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
+
+func loadSearchIndex(searchPath string, source os.FileInfo) (*searchIndex, error) {
+	var search searchIndex
+	if err := readGob(searchPath, &search); err != nil {
+		return nil, err
+	}
+	if search.SourceSize != source.Size() || !search.SourceModTime.Equal(source.ModTime()) {
+		return nil, errStaleSearchIndex
+	}
+	return &search, nil
+}
+
+// readGob decodes the gob encoded file at path into v
+func readGob(path string, v any) error {
+	gobFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer gobFile.Close()
+	return gob.NewDecoder(bufio.NewReader(gobFile)).Decode(v)
+}
+
+// writeGob atomically replaces the file at path by the gob encoding of v
+func writeGob(path string, v any) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	buffered := bufio.NewWriter(tmpFile)
+	if err := gob.NewEncoder(buffered).Encode(v); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// startSearch makes full text search available using the search index at
+// searchPath. If it is missing or outdated and build is set, it is rebuilt in
+// the background while the server already answers all other requests.
+func (h *TinyWikiHandler) startSearch(searchPath string, build bool) {
+	info, err := statContent(h.contentFilePath)
+	if err != nil {
+		logError("Search disabled:", err)
+		return
+	}
+	search, err := loadSearchIndex(searchPath, info)
+	if err == nil {
+		logInfo("Loaded search index", searchPath)
+		h.search.Store(search)
+		return
+	}
+	if !os.IsNotExist(err) {
+		logInfo("Ignoring search index:", err)
+	}
+	if !build {
+		return
+	}
+	h.searchBuild.start(func() {
+		logInfo("Building search index", searchPath)
+		search, err := buildSearchIndex(h.contentFilePath, h.index())
+		if err != nil {
+			logError("Couldn't build search index:", err)
+			return
+		}
+		h.search.Store(search)
+		logInfo("Search index built with", len(search.Docs), "articles")
+		if err := writeGob(searchPath, search); err != nil {
+			logError("Couldn't write search index:", err)
+		}
+	})
+}
+
+// backgroundBuild runs the builds of an index in the background one at a
+// time. A build started while another one is running is run once that one is
+// done, as the running one may be working on an index replaced since.
+type backgroundBuild struct {
+	mu      sync.Mutex
+	running bool
+	next    func()
+}
+
+func (b *backgroundBuild) start(build func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running {
+		b.next = build
+		return
+	}
+	b.running = true
+	go func() {
+		for build != nil {
+			build()
+			b.mu.Lock()
+			build, b.next = b.next, nil
+			b.running = build != nil
+			b.mu.Unlock()
+		}
+	}()
+}
+
+var searchTemplate = template.Must(template.New("search").Funcs(template.FuncMap{
+	"wikiURL": wikiURL,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{if .Query}}{{.Query}} - {{end}}Search</title>
+<link rel="icon" href="/favicon.ico">
+<link rel="stylesheet" href="/tinypedia.css">
+` + openSearchLink + `
+</head>
+<body>
+<form action="search">
+<input type="search" name="q" value="{{.Query}}" autofocus>
+<input type="submit" value="Search">
+</form>
+{{if .Query}}{{if .Results}}<p>Results for <b>{{.Query}}</b>{{if not .FullText}} among the titles{{end}}</p>
+<ul>
+{{range .Results}}<li><a href="{{wikiURL $.LinkBase .}}">{{.}}</a></li>
+{{end}}</ul>
+{{else}}<p>No results for <b>{{.Query}}</b>.</p>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// ServeSearch serves an HTML page with the articles matching the q
+// parameter. With a full text search index the article texts are searched,
+// otherwise only the titles are.
+func (h *TinyWikiHandler) ServeSearch(w http.ResponseWriter, r *http.Request) {
+	if !allowReadMethods(w, r) {
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	var results []string
+	fullText := false
+	if q != "" {
+		results, fullText = h.searchTitles(q, defaultSearchLimit)
+	}
+	var page strings.Builder
+	err := searchTemplate.Execute(&page, struct {
+		Query    string
+		Results  []string
+		FullText bool
+		LinkBase string
+	}{q, results, fullText, h.linkBase})
+	if err != nil {
+		logError(err)
+		http.Error(w, "failed to render search results", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, r, "text/html; charset=utf-8", page.String())
+}
+
+// searchTitles returns up to limit titles matching q from the full text index
+// if there is one. Otherwise the exact title, titles starting with q and
+// similar titles are returned and fullText is false.
+func (h *TinyWikiHandler) searchTitles(q string, limit int) (titles []string, fullText bool) {
+	if search := h.search.Load(); search != nil {
+		for _, result := range search.query(q, limit, h.index()) {
+			titles = append(titles, result.Title)
+		}
+		return titles, true
+	}
+	index := h.index()
+	title := normalizeTitle(q)
+	seen := make(map[string]bool)
+	add := func(candidates ...string) {
+		for _, candidate := range candidates {
+			if len(titles) < limit && !seen[candidate] {
+				seen[candidate] = true
+				titles = append(titles, candidate)
+			}
+		}
+	}
+	if _, ok := index.Lookup(title); ok {
+		add(title)
+	}
+	add(completeTitles(index, title, limit)...)
+	add(suggestTitles(index, title)...)
+	return titles, false
+}
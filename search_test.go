@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackgroundBuildRunsOneAtATime(t *testing.T) {
+	var b backgroundBuild
+	var running, runs atomic.Int32
+	release := make(chan struct{})
+	var done sync.WaitGroup
+	done.Add(2)
+	build := func() {
+		defer done.Done()
+		if running.Add(1) > 1 {
+			t.Error("builds overlap")
+		}
+		<-release
+		runs.Add(1)
+		running.Add(-1)
+	}
+	b.start(build)
+	// Builds started while one is running collapse into a single one
+	// run after it
+	for i := 0; i < 5; i++ {
+		b.start(build)
+	}
+	close(release)
+	done.Wait()
+	time.Sleep(10 * time.Millisecond)
+	if got := runs.Load(); got != 2 {
+		t.Errorf("got %d builds, want 2", got)
+	}
+	if buildRunning(&b) {
+		t.Error("still running after the last build")
+	}
+}
+
+func buildRunning(b *backgroundBuild) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.running
+}
+
+func TestStartSearchBuildsIndex(t *testing.T) {
+	h := newTestHandler(t)
+	searchPath := filepath.Join(t.TempDir(), "search.gob")
+	h.startSearch(searchPath, true)
+	// A restart, like on SIGHUP, while the first build may still run
+	h.startSearch(searchPath, true)
+	deadline := time.Now().Add(10 * time.Second)
+	for buildRunning(&h.searchBuild) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec := get(http.HandlerFunc(h.ServeSearchJSON), "/api/search?q=cryptanalysis&limit=3")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"title":"Sample 0`) {
+		t.Errorf("got %d %q, want Sample pages", rec.Code, rec.Body.String())
+	}
+}
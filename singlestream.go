@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+var errSingleStreamTooLarge = errors.New("compressed single stream dump too large, decompress it first")
+
+// maxCompressedSingleStream bounds the size of compressed single stream dumps
+// that are served. Every article of one is decompressed from the start of the
+// dump on, which takes minutes for articles far into a dump of gigabytes.
+var maxCompressedSingleStream int64 = 256 << 20
+
+// scanPageOffsets reads a decompressed dump and calls fn with the byte offset
+// of every <page> start tag along with the page's id and title. These offsets
+// take the place of stream offsets so every page is a stream of its own.
+func scanPageOffsets(dump io.Reader, fn func(offset int64, id uint64, title string)) error {
+	dexml := xml.NewDecoder(bufio.NewReaderSize(newOffsetPreservingUTF8Reader(dump), 1<<20))
+	dexml.Strict = false
+	var (
+		start, depth int64
+		title, value string
+		id           uint64
+		idSeen       bool
+	)
+	for {
+		// Before a start tag the decoder is positioned right at its <
+		offset := dexml.InputOffset()
+		tok, err := dexml.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth++
+			value = ""
+			if tok.Name.Local == "page" {
+				start, depth, title, idSeen = offset, 0, "", false
+			} else if depth == 1 && tok.Name.Local == "revision" {
+				// Nothing below the revision is needed
+				if err := dexml.Skip(); err != nil {
+					return err
+				}
+				depth--
+			}
+		case xml.EndElement:
+			depth--
+			switch {
+			case tok.Name.Local == "page":
+				if idSeen {
+					fn(start, id, title)
+				}
+			case depth == 0 && tok.Name.Local == "title":
+				title = value
+			case depth == 0 && tok.Name.Local == "id" && !idSeen:
+				id, err = strconv.ParseUint(value, 10, 64)
+				idSeen = err == nil
+			}
+		case xml.CharData:
+			value += string(tok)
+		}
+	}
+}
+
+// singleStreamOffsets builds the offset map of the single stream dump at
+// contentPath by reading it once, keeping the titles in namespaces. The
+// offsets of a compressed dump count the decompressed bytes. The map is
+// cached in cachePath like one read from an index file, it is tied to the
+// dump instead. Compressed dumps larger than maxCompressedSingleStream are
+// refused.
+func singleStreamOffsets(contentPath, cachePath string, namespaces namespaceSet) (map[string]OffsetAndId, error) {
+	decompress, err := decompressorFor(contentPath)
+	if err != nil {
+		return nil, err
+	}
+	dump, err := openContent(contentPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dump.Close()
+	info, err := dump.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if isCompressed(contentPath) && info.Size() > maxCompressedSingleStream {
+		return nil, fmt.Errorf("%s has %d bytes, more than %d: %w", contentPath, info.Size(), maxCompressedSingleStream, errSingleStreamTooLarge)
+	}
+	if cachePath != "" {
+		offsetMap, err := loadIndexCache(cachePath, info, namespaces, nil)
+		if err == nil {
+			logInfo("Loaded index from cache", cachePath)
+			return offsetMap, nil
+		}
+		if !os.IsNotExist(err) {
+			logInfo("Ignoring index cache:", err)
+		}
+	}
+	logInfo("Indexing the pages of", contentPath)
+	contentStream, err := decompress(bufio.NewReaderSize(io.NewSectionReader(dump, 0, info.Size()), remoteBlockSize))
+	if err != nil {
+		return nil, err
+	}
+	offsetMap := make(map[string]OffsetAndId)
+	err = scanPageOffsets(contentStream, func(offset int64, id uint64, title string) {
+		if namespaces.allows(title) {
+			offsetMap[title] = OffsetAndId{offset, id}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	logInfo("Indexed", len(offsetMap), "pages")
+	if cachePath != "" {
+		if err := writeIndexCache(cachePath, info, namespaces, nil, offsetMap); err != nil {
+			logError("Couldn't write index cache:", err)
+		} else {
+			logInfo("Wrote index cache", cachePath)
+		}
+	}
+	return offsetMap, nil
+}
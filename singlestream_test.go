@@ -0,0 +1,126 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// singleStreamDumps writes the single stream fixture decompressed and gzip
+// compressed and returns the paths of the fixture and both copies along with
+// the decompressed content
+func singleStreamDumps(t *testing.T) ([]string, string) {
+	t.Helper()
+	f, err := os.Open("testdata/single.xml.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	content, err := io.ReadAll(bzip2.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	plainPath, gzipPath := filepath.Join(dir, "single.xml"), filepath.Join(dir, "single.xml.gz")
+	if err := os.WriteFile(plainPath, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gzipFile, err := os.Create(gzipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(gzipFile)
+	zw.Write(content)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return []string{"testdata/single.xml.bz2", gzipPath, plainPath}, string(content)
+}
+
+func TestSingleStream(t *testing.T) {
+	paths, content := singleStreamDumps(t)
+	singleStream = true
+	defer func() { singleStream = false }()
+	want := map[string]string{
+		"Alan_Turing":   "'''Alan Mathison Turing''' was an English",
+		"New_York_City": "'''New York City''' is a city",
+		"Mercury":       "'''Mercury''' may refer to:",
+		"Éclair":        "An '''éclair''' is a pastry filled with cream.",
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			h := loadTestWiki(t, "", path, defaultLinkBase)
+			// Offsets count the decompressed bytes up to the <page> tag
+			wantOffset := int64(strings.Index(content, "<page>"))
+			if offsetAndId, _ := h.index().Lookup("Alan Turing"); offsetAndId != (OffsetAndId{wantOffset, 10}) {
+				t.Errorf("Alan Turing at %v, want {%d 10}", offsetAndId, wantOffset)
+			}
+			if _, ok := h.index().Lookup("Talk:Alan Turing"); ok {
+				t.Error("page outside of the namespaces indexed")
+			}
+			for _, chunkCacheBytes := range []int64{0, 1 << 20} {
+				h.data.Store(newWikiData(h.index(), h.data.Load().dump, 0, chunkCacheBytes))
+				for title, text := range want {
+					for _, target := range []string{"/wiki/" + title + "?action=raw", "/wiki/" + title + "?action=raw&stream=1"} {
+						rec := get(wikiRoute(h), target)
+						if rec.Code != 200 || !strings.Contains(rec.Body.String(), text) {
+							t.Errorf("%s with a chunk cache of %d bytes: got %d %.60q, want it to contain %q",
+								target, chunkCacheBytes, rec.Code, rec.Body.String(), text)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSingleStreamCache(t *testing.T) {
+	namespaces, err := parseNamespaces("0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachePath := filepath.Join(t.TempDir(), "index.cache")
+	built, err := singleStreamOffsets("testdata/single.xml.bz2", cachePath, namespaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cachePath); err != nil {
+		t.Fatalf("no cache written: %v", err)
+	}
+	cached, err := singleStreamOffsets("testdata/single.xml.bz2", cachePath, namespaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(built) != 7 || !reflect.DeepEqual(cached, built) {
+		t.Errorf("got %v from the cache, want the %d titles built %v", cached, len(built), built)
+	}
+}
+
+func TestSingleStreamSizeLimit(t *testing.T) {
+	paths, _ := singleStreamDumps(t)
+	singleStream = true
+	defer func(limit int64) { singleStream, maxCompressedSingleStream = false, limit }(maxCompressedSingleStream)
+	namespaces, err := parseNamespaces("0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The limit doesn't apply to the decompressed dump
+	maxCompressedSingleStream = 100
+	if _, err := loadWiki("", paths[2], "", defaultLinkBase, namespaces); err != nil {
+		t.Errorf("%s: got %v", paths[2], err)
+	}
+	for _, path := range paths[:2] {
+		if _, err := loadWiki("", path, "", defaultLinkBase, namespaces); !errors.Is(err, errSingleStreamTooLarge) {
+			t.Errorf("%s: got %v, want %v", path, err, errSingleStreamTooLarge)
+		}
+	}
+}
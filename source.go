@@ -0,0 +1,69 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+)
+
+// SourceRenderer shows the wikitext itself as HTML with templates, links,
+// headings and comments wrapped in spans of the classes wt-template,
+// wt-link, wt-heading and wt-comment so they can be highlighted
+type SourceRenderer struct{}
+
+func (SourceRenderer) Render(wikitext string) ([]byte, string) {
+	return []byte("<pre class=\"wikitext\">" + highlightWikitext(wikitext) + "</pre>\n"), htmlContentType
+}
+
+// highlightWikitext escapes wikitext and wraps its constructs in spans.
+// Templates and links nest, a closing }} or ]] without its opening
+// counterpart is left as text. Headings are only recognized outside of
+// templates and links.
+func highlightWikitext(wikitext string) string {
+	var b strings.Builder
+	var open []string
+	lineStart := true
+	for len(wikitext) > 0 {
+		if lineStart && len(open) == 0 {
+			line, _, _ := strings.Cut(wikitext, "\n")
+			if headingRegexp.MatchString(strings.TrimSpace(line)) {
+				b.WriteString("<span class=\"wt-heading\">" + template.HTMLEscapeString(line) + "</span>")
+				wikitext = wikitext[len(line):]
+				lineStart = false
+				continue
+			}
+		}
+		switch {
+		case strings.HasPrefix(wikitext, "<!--"):
+			end := strings.Index(wikitext, "-->")
+			if end < 0 {
+				end = len(wikitext)
+			} else {
+				end += len("-->")
+			}
+			b.WriteString("<span class=\"wt-comment\">" + template.HTMLEscapeString(wikitext[:end]) + "</span>")
+			lineStart = strings.HasSuffix(wikitext[:end], "\n")
+			wikitext = wikitext[end:]
+			continue
+		case strings.HasPrefix(wikitext, "{{"):
+			b.WriteString("<span class=\"wt-template\">{{")
+			open = append(open, "}}")
+		case strings.HasPrefix(wikitext, "[["):
+			b.WriteString("<span class=\"wt-link\">[[")
+			open = append(open, "]]")
+		case len(open) > 0 && strings.HasPrefix(wikitext, open[len(open)-1]):
+			b.WriteString(open[len(open)-1] + "</span>")
+			open = open[:len(open)-1]
+		default:
+			lineStart = wikitext[0] == '\n'
+			b.WriteString(template.HTMLEscapeString(wikitext[:1]))
+			wikitext = wikitext[1:]
+			continue
+		}
+		lineStart = false
+		wikitext = wikitext[2:]
+	}
+	for range open {
+		b.WriteString("</span>")
+	}
+	return b.String()
+}
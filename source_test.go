@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHighlightWikitext(t *testing.T) {
+	tests := []struct {
+		name, wikitext, want string
+	}{
+		{"escaped", `a < b & "c"`, "a &lt; b &amp; &#34;c&#34;"},
+		{"template", "{{Infobox|name=<b>}}", `<span class="wt-template">{{Infobox|name=&lt;b&gt;}}</span>`},
+		{"nested", "{{a|[[B|{{c}}]]}}", `<span class="wt-template">{{a|<span class="wt-link">[[B|<span class="wt-template">{{c}}</span>]]</span>}}</span>`},
+		{"heading", "Text\n== Life ==\nMore", "Text\n" + `<span class="wt-heading">== Life ==</span>` + "\nMore"},
+		{"heading inside a template", "{{a|\n== b ==\n}}", `<span class="wt-template">{{a|` + "\n== b ==\n" + `}}</span>`},
+		{"not a heading mid line", "a == b ==", "a == b =="},
+		{"comment", "a<!-- [[b]] -->c", `a<span class="wt-comment">&lt;!-- [[b]] --&gt;</span>c`},
+		{"unclosed", "[[a {{b", `<span class="wt-link">[[a <span class="wt-template">{{b</span></span>`},
+		{"stray closing", "a]] b}}", "a]] b}}"},
+		{"unicode", "[[Éclair]] café", `<span class="wt-link">[[Éclair]]</span> café`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlightWikitext(tt.wikitext); got != tt.want {
+				t.Errorf("highlightWikitext(%q) = %q, want %q", tt.wikitext, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestViewSource(t *testing.T) {
+	routes := wikiRoute(newTestHandler(t))
+	rec := get(routes, "/wiki/Alan_Turing?view=source")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != htmlContentType {
+		t.Fatalf("got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"<title>Alan Turing",
+		`<pre class="wikitext">`,
+		`<span class="wt-heading">== Career ==</span>`,
+		`<span class="wt-link">[[Bletchley Park#Huts|Bletchley]]</span>`,
+		`<span class="wt-template">{{birth date|1912|6|23}}</span>`,
+		"&#39;&#39;&#39;Alan Mathison Turing&#39;&#39;&#39;",
+		"&lt;ref&gt;Some ref&lt;/ref&gt;",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in %q", want, body)
+		}
+	}
+	// Nothing of the rendered view is mixed in
+	if strings.Contains(body, `<a href="/wiki/Bletchley_Park`) || strings.Contains(body, `id="toc"`) {
+		t.Errorf("source view contains rendered markup: %q", body)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// splitDumpFile is one of the stream files of a split dump. Base is the
+// offset of its first byte in the concatenation of all files.
+type splitDumpFile struct {
+	name string
+	base int64
+	size int64
+}
+
+// splitDumpListing lists the stream files of a split dump. It is taken once
+// per load of the index and shared by all splitDumps reading the files.
+type splitDumpListing struct {
+	dir     string
+	files   []splitDumpFile
+	size    int64
+	modTime time.Time
+}
+
+// splitDump reads a directory of bzip2 stream files as if they were
+// concatenated into a single multistream dump, sorted by file name. This way
+// offsets into the split dump work just like offsets into a multistream file.
+// The files are only opened once they are read from.
+type splitDump struct {
+	*splitDumpListing
+
+	mu     sync.Mutex
+	opened map[int]*os.File
+}
+
+// isSplitDump reports whether the dump at path is a directory of stream files
+func isSplitDump(path string) bool {
+	if isURL(path) {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func listSplitDump(dir string) (*splitDumpListing, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	listing := &splitDumpListing{dir: dir}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".bz2" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		listing.files = append(listing.files, splitDumpFile{entry.Name(), listing.size, info.Size()})
+		listing.size += info.Size()
+		if info.ModTime().After(listing.modTime) {
+			listing.modTime = info.ModTime()
+		}
+	}
+	if len(listing.files) == 0 {
+		return nil, fmt.Errorf("no .bz2 stream files in %s", dir)
+	}
+	return listing, nil
+}
+
+func openSplitDump(dir string) (*splitDump, error) {
+	listing, err := listSplitDump(dir)
+	if err != nil {
+		return nil, err
+	}
+	return listing.open(), nil
+}
+
+// open returns a reader of the listed files with file handles of its own
+func (l *splitDumpListing) open() *splitDump {
+	return &splitDump{splitDumpListing: l, opened: make(map[int]*os.File)}
+}
+
+// bases returns the base offset of every stream file keyed by file name
+func (l *splitDumpListing) bases() map[string]int64 {
+	bases := make(map[string]int64, len(l.files))
+	for _, file := range l.files {
+		bases[file.name] = file.base
+	}
+	return bases
+}
+
+// fileAt returns the position of the file containing offset
+func (d *splitDumpListing) fileAt(offset int64) int {
+	return sort.Search(len(d.files), func(i int) bool {
+		return d.files[i].base+d.files[i].size > offset
+	})
+}
+
+// locate translates an offset into the split dump into the name of the file
+// it falls into and the offset within that file
+func (d *splitDumpListing) locate(offset int64) (string, int64) {
+	file := d.files[d.fileAt(offset)]
+	return file.name, offset - file.base
+}
+
+func (d *splitDump) open(i int) (*os.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, ok := d.opened[i]; ok {
+		return f, nil
+	}
+	f, err := os.Open(filepath.Join(d.dir, d.files[i].name))
+	if err != nil {
+		return nil, err
+	}
+	d.opened[i] = f
+	return f, nil
+}
+
+// ReadAt reads from the file containing off and continues with the following
+// files if p reaches past its end
+func (d *splitDump) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	for i := d.fileAt(off); read < len(p); i++ {
+		if i >= len(d.files) {
+			return read, io.EOF
+		}
+		f, err := d.open(i)
+		if err != nil {
+			return read, err
+		}
+		file := d.files[i]
+		want := min(int64(len(p)-read), file.base+file.size-off)
+		n, err := f.ReadAt(p[read:read+int(want)], off-file.base)
+		read += n
+		off += int64(n)
+		if err != nil && err != io.EOF {
+			return read, err
+		}
+		if int64(n) < want {
+			// The file shrank since the directory was listed
+			return read, io.ErrUnexpectedEOF
+		}
+	}
+	return read, nil
+}
+
+func (d *splitDump) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var firstErr error
+	for i, f := range d.opened {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(d.opened, i)
+	}
+	return firstErr
+}
+
+// Stat describes the split dump as a single file with the combined size and
+// the modification time of the most recently changed stream file
+func (d *splitDump) Stat() (os.FileInfo, error) {
+	return &remoteFileInfo{filepath.Base(d.dir), d.size, d.modTime}, nil
+}
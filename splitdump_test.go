@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitDumpListedOnce(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"stream1.xml.bz2", "stream2.xml.bz2"} {
+		copyFile(t, filepath.Join("testdata/split", name), filepath.Join(dir, name))
+	}
+	h := loadTestWiki(t, "testdata/split-index.txt", dir, defaultLinkBase)
+	mercury := func() string {
+		t.Helper()
+		rec := get(wikiRoute(h), "/wiki/Mercury?action=raw")
+		if rec.Code != 200 {
+			t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+	want := mercury()
+
+	// A file sorting first shifts the base offsets of all others, the
+	// listing taken at load time keeps them until the next reload
+	copyFile(t, "testdata/split/stream2.xml.bz2", filepath.Join(dir, "stream0.xml.bz2"))
+	if got := mercury(); got != want {
+		t.Fatalf("before reload: got %q, want %q", got, want)
+	}
+	if err := h.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.data.Load().dump.fileBases(); len(got) != 3 {
+		t.Fatalf("reload kept the old listing %v", got)
+	}
+	if got := mercury(); got != want {
+		t.Fatalf("after reload: got %q, want %q", got, want)
+	}
+}
+
+func TestIndexCacheKeyedOnFileBases(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "index.cache")
+	info, err := os.Stat("testdata/split-index.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces, err := parseNamespaces("0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bases := map[string]int64{"stream1.xml.bz2": 0, "stream2.xml.bz2": 953}
+	offsetMap := map[string]OffsetAndId{"Mercury": {953, 21}}
+	if err := writeIndexCache(cachePath, info, namespaces, bases, offsetMap); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := loadIndexCache(cachePath, info, namespaces, bases); err != nil || got["Mercury"] != offsetMap["Mercury"] {
+		t.Fatalf("same files: got %v, %v", got, err)
+	}
+	resized := map[string]int64{"stream1.xml.bz2": 0, "stream2.xml.bz2": 1000}
+	if _, err := loadIndexCache(cachePath, info, namespaces, resized); err != errStaleIndexCache {
+		t.Fatalf("resized file: got %v, want %v", err, errStaleIndexCache)
+	}
+	if _, err := loadIndexCache(cachePath, info, namespaces, nil); err != errStaleIndexCache {
+		t.Fatalf("no split dump: got %v, want %v", err, errStaleIndexCache)
+	}
+}
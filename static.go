@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"os"
+)
+
+// startPage is served at / when there is no static directory
+const startPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Tinypedia</title>
+` + openSearchLink + `
+</head>
+<body>
+<h1>Tinypedia</h1>
+<form action="/search">
+<input type="search" name="q" autofocus>
+<input type="submit" value="Search">
+</form>
+<p><a href="/random">Random article</a></p>
+</body>
+</html>
+`
+
+// staticHandler serves the files in dir. If dir doesn't exist only the
+// built-in start page is served so the binary works from anywhere.
+func staticHandler(dir string) http.Handler {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return http.FileServer(http.Dir(dir))
+	}
+	logInfo("No static directory", dir, "serving the built-in start page")
+	return http.HandlerFunc(serveStartPage)
+}
+
+func serveStartPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowReadMethods(w, r) {
+		return
+	}
+	writeBody(w, r, "text/html; charset=utf-8", startPage)
+}
@@ -73,7 +73,7 @@ function locationHashChanged() {
 }
 
 function loadArticle(title) {
-  $.get('wiki/'+encodeURIComponent(title), function(markup){
+  $.get('wiki/'+encodeURIComponent(title)+'?action=raw', function(markup){
     ast = wtf.parse(markup)
     /**
      * Handle page redirect's e.g. Moody's ⇒ Moody's Investors Service
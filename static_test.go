@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStaticHandlerWithoutDirectory(t *testing.T) {
+	handler := staticHandler(filepath.Join(t.TempDir(), "missing"))
+	rec := get(handler, "/")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" || rec.Body.String() != startPage {
+		t.Errorf("/: got %d %q with %.60q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `action="/search"`) || !strings.Contains(rec.Body.String(), "/opensearch.xml") {
+		t.Errorf("start page lacks the search form or OpenSearch link: %q", rec.Body.String())
+	}
+	for _, target := range []string{"/index.html", "/tinypedia.css", "/favicon.ico"} {
+		if rec := get(handler, target); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d, want %d", target, rec.Code, http.StatusNotFound)
+		}
+	}
+	if rec := get(staticHandler("static"), "/tinypedia.css"); rec.Code != http.StatusOK {
+		t.Errorf("/tinypedia.css from static: got %d", rec.Code)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+var errUnexpectedMarkup = errors.New("unexpected markup in <text>")
+
+// maxEntityLen bounds the length of an entity like &#x1D11E; in the text
+const maxEntityLen = 16
+
+// streamPage writes the wikitext of the page with the id offId.Id to w while
+// its stream is decompressed. Unlike extractPage the text is never held in
+// memory as a whole, the XML decoder which would collect it into a single
+// token is bypassed once the <text> element starts. Right before the text
+// start is called with the page's metadata read so far.
+func streamPage(ctx context.Context, dump dumpSource, offId OffsetAndId, end int64, w io.Writer, start func(page *wikiPage)) error {
+	contentStream, multiStream, err := openStream(ctx, dump, offId.Offset, end)
+	if err != nil {
+		return err
+	}
+	defer multiStream.Close()
+	found := false
+	err = decodePages(bufio.NewReader(newValidUTF8Reader(contentStream)), func(page *wikiPage) bool {
+		return page.Id == offId.Id
+	}, func(page *wikiPage) bool {
+		// The page ended without any text
+		return false
+	}, func(page *wikiPage, text xml.StartElement, raw *bufio.Reader) error {
+		found = true
+		start(page)
+		if isEmptyText(text) {
+			return nil
+		}
+		return copyText(w, raw)
+	})
+	if err != nil {
+		return fmt.Errorf("streaming id %d from stream at offset %d: %w", offId.Id, offId.Offset, err)
+	}
+	if !found {
+		return errArticleNotFound
+	}
+	return nil
+}
+
+// isEmptyText reports whether the <text> element is one the dumps write as
+// self closing because the revision is deleted or empty
+func isEmptyText(text xml.StartElement) bool {
+	for _, attr := range text.Attr {
+		if attr.Name.Local == "deleted" || attr.Name.Local == "bytes" && attr.Value == "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// copyText copies the character data of a <text> element from raw to w,
+// replacing entities the way the XML decoder does, and stops after the
+// closing tag. A leading byte order mark is dropped. If reading fails the
+// text copied so far is still written to w.
+func copyText(w io.Writer, raw *bufio.Reader) error {
+	if bom, err := raw.Peek(3); err == nil && string(bom) == "\uFEFF" {
+		raw.Discard(3)
+	}
+	out := bufio.NewWriterSize(w, 32<<10)
+	for {
+		c, err := raw.ReadByte()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			out.Flush()
+			return err
+		}
+		switch c {
+		case '<':
+			closing, err := raw.Peek(len("/text>"))
+			if err != nil || string(closing) != "/text>" {
+				return errUnexpectedMarkup
+			}
+			return out.Flush()
+		case '&':
+			entity, err := raw.ReadSlice(';')
+			if err != nil || len(entity) > maxEntityLen {
+				return fmt.Errorf("unterminated entity in <text>: %w", err)
+			}
+			out.WriteString(decodeEntities("&" + string(entity)))
+		case '\r':
+			// Line ends are normalized to \n like XML requires
+			if next, err := raw.Peek(1); err != nil || next[0] != '\n' {
+				out.WriteByte('\n')
+			}
+		default:
+			out.WriteByte(c)
+		}
+	}
+}
+
+// flushingWriter sends every write to the client right away so a streamed
+// article arrives while it is still being decompressed
+type flushingWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushingWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+// streamDeadline cancels streaming an article once decompressing it took
+// longer than its limit. The clock is paused while a write to the client is
+// pending, so a slow client doesn't use up the time decompression may take.
+type streamDeadline struct {
+	left    time.Duration
+	resumed time.Time
+	timer   *time.Timer
+	paused  bool
+	expired bool
+}
+
+func newStreamDeadline(limit time.Duration, cancel context.CancelFunc) *streamDeadline {
+	return &streamDeadline{left: limit, resumed: time.Now(), timer: time.AfterFunc(limit, cancel)}
+}
+
+// pause stops the clock and reports whether the deadline passed already
+func (d *streamDeadline) pause() bool {
+	if d.paused || d.expired {
+		return d.expired
+	}
+	if d.timer.Stop() {
+		d.left -= time.Since(d.resumed)
+		d.paused = true
+	} else {
+		d.expired = true
+	}
+	return d.expired
+}
+
+func (d *streamDeadline) resume() {
+	if !d.paused {
+		return
+	}
+	d.paused = false
+	d.resumed = time.Now()
+	d.timer.Reset(d.left)
+}
+
+// deadlineWriter pauses deadline while writing to w
+type deadlineWriter struct {
+	w        io.Writer
+	deadline *streamDeadline
+}
+
+func (d deadlineWriter) Write(p []byte) (int, error) {
+	d.deadline.pause()
+	defer d.deadline.resume()
+	return d.w.Write(p)
+}
+
+// streamRaw serves the wikitext of the article named by the request path
+// while it is decompressed, for articles too large to be collected in memory
+// for every request. Streamed articles bypass the caches. An article whose
+// decompression takes longer than the extractTimeout is cut off where it got
+// to, marked as truncated at its end and with an X-Truncated trailer. Time
+// spent waiting for the client to take the text doesn't count.
+func (h *TinyWikiHandler) streamRaw(w http.ResponseWriter, r *http.Request) {
+	data := h.data.Load()
+	title, offsetAndId, ok := data.findTitle(r.URL.Path)
+	noteTitle(r, title)
+	if !ok {
+		h.notFound(w, r, title)
+		return
+	}
+	release, err := acquireExtraction(r.Context())
+	if err != nil {
+		status, message := extractionErrorStatus(err)
+		writeError(w, r, message, status)
+		return
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var out io.Writer = flushingWriter{w}
+	var deadline *streamDeadline
+	if h.extractTimeout > 0 {
+		deadline = newStreamDeadline(h.extractTimeout, cancel)
+		out = deadlineWriter{out, deadline}
+	}
+	started := false
+	end := streamEnd(data.streams, offsetAndId.Offset)
+	err = streamPage(ctx, data.dump, offsetAndId, end, out, func(page *wikiPage) {
+		started = true
+		h.setLastModified(w, page)
+		w.Header().Set("Content-Type", textContentType)
+		w.Header().Set("Trailer", "X-Truncated")
+	})
+	if deadline != nil && deadline.pause() && err != nil && r.Context().Err() == nil {
+		// Canceled by the deadline rather than the client
+		err = fmt.Errorf("streaming %q: %w", title, context.DeadlineExceeded)
+	}
+	switch {
+	case err == errArticleNotFound:
+		h.notFound(w, r, title)
+	case err != nil && !started:
+		logError(err)
+		status, message := extractionErrorStatus(err)
+		writeError(w, r, message, status)
+	case errors.Is(err, context.DeadlineExceeded):
+		logInfo("Streaming", title, "timed out, sent it truncated")
+		io.WriteString(w, truncationMarker)
+		w.Header().Set("X-Truncated", "true")
+	case err != nil:
+		// Part of the article has been sent already, so the client
+		// can only learn about the error from the aborted connection
+		logError(err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// largeText returns an article of at least n bytes
+func largeText(n int) string {
+	var text strings.Builder
+	for i := 0; text.Len() < n; i++ {
+		text.WriteString("Line with <markup> & entities, Umlaute äöü and ")
+		text.WriteString(strings.Repeat("x", i%50))
+		text.WriteString("\n")
+	}
+	return text.String()
+}
+
+func TestStreamLargeArticle(t *testing.T) {
+	text := largeText(4 << 20)
+	indexPath, contentPath := writeGzipDump(t, "Small", "A small page", "Large", text, "After", "The page after")
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	rec := get(wikiRoute(h), "/wiki/Large?action=raw&stream=1")
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != textContentType {
+		t.Fatalf("got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if got := rec.Body.String(); got != text {
+		t.Errorf("got %d bytes, want the %d bytes of the article intact", len(got), len(text))
+	}
+	if !rec.Flushed {
+		t.Error("the article wasn't flushed while streaming")
+	}
+	if rec := get(wikiRoute(h), "/wiki/After?action=raw&stream=1"); rec.Body.String() != "The page after" {
+		t.Errorf("page after the large one: got %q", rec.Body.String())
+	}
+}
+
+func TestStreamTimeoutTruncates(t *testing.T) {
+	text := largeText(16 << 20)
+	indexPath, contentPath := writeGzipDump(t, "Large", text)
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	h.extractTimeout = 20 * time.Millisecond
+	rec := get(wikiRoute(h), "/wiki/Large?action=raw&stream=1")
+	if rec.Code != 200 {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+	body, ok := strings.CutSuffix(rec.Body.String(), truncationMarker)
+	if !ok || len(body) == len(text) || !strings.HasPrefix(text, body) {
+		t.Errorf("got %d bytes ending in %q, want a part of the %d bytes and the truncation marker",
+			rec.Body.Len(), rec.Body.String()[max(rec.Body.Len()-40, 0):], len(text))
+	}
+	if got := rec.Result().Trailer.Get("X-Truncated"); got != "true" {
+		t.Errorf("got X-Truncated trailer %q", got)
+	}
+}
+
+// slowWriter takes a while for every write like a client on a slow
+// connection
+type slowWriter struct {
+	*httptest.ResponseRecorder
+	delay time.Duration
+}
+
+func (w slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	return w.ResponseRecorder.Write(p)
+}
+
+// randomText returns n bytes of letters that barely compress, so their
+// decompression keeps reading the dump
+func randomText(n int) string {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	text := make([]byte, n)
+	for i := range text {
+		text[i] = byte('a' + rnd.IntN(26))
+	}
+	return string(text)
+}
+
+func TestStreamTimeoutIgnoresSlowClient(t *testing.T) {
+	text := randomText(512 << 10)
+	indexPath, contentPath := writeGzipDump(t, "Large", text)
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	h.extractTimeout = time.Second
+	// The writes of 32KiB each take longer than the timeout altogether
+	rec := httptest.NewRecorder()
+	w := slowWriter{rec, 100 * time.Millisecond}
+	wikiRoute(h).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/wiki/Large?action=raw&stream=1", nil))
+	if rec.Code != 200 || rec.Body.String() != text {
+		t.Errorf("got %d with %d bytes, want the %d bytes of the article intact", rec.Code, rec.Body.Len(), len(text))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// spanRegexp matches the only cell attributes kept when rendering tables
+var spanRegexp = regexp.MustCompile(`(?i)\b(colspan|rowspan)\s*=\s*"?(\d+)"?`)
+
+// tableEnd returns the index of the line closing the table opened in
+// lines[start], taking nested tables into account. An unclosed table ends
+// with the text.
+func tableEnd(lines []string, start int) int {
+	depth := 0
+	for i := start; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		switch {
+		case strings.HasPrefix(trimmed, "{|"):
+			depth++
+		case strings.HasPrefix(trimmed, "|}"):
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(lines)
+}
+
+type tableCell struct {
+	header  bool
+	attrs   string
+	content []string
+}
+
+// renderTable converts the lines of a {| ... |} table between its opening
+// and closing line into an HTML table. Captions, header and data cells are
+// supported, of the cell attributes only colspan and rowspan are kept.
+// Nested tables are rendered as part of the cell containing them.
+func renderTable(lines []string, linkBase string) string {
+	var (
+		b       strings.Builder
+		caption []string
+		rows    [][]*tableCell
+		cell    *tableCell
+	)
+	newRow := func() {
+		rows = append(rows, nil)
+	}
+	addCell := func(header bool, text string) {
+		if len(rows) == 0 {
+			newRow()
+		}
+		cell = &tableCell{header: header}
+		if attrs, content, ok := splitCellAttrs(text); ok {
+			cell.attrs, text = attrs, content
+		}
+		cell.content = []string{strings.TrimSpace(text)}
+		rows[len(rows)-1] = append(rows[len(rows)-1], cell)
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "{|") && cell != nil:
+			end := tableEnd(lines, i)
+			if end == len(lines) {
+				end = len(lines) - 1
+			}
+			cell.content = append(cell.content, lines[i:end+1]...)
+			i = end
+		case strings.HasPrefix(trimmed, "|+"):
+			caption = append(caption, strings.TrimSpace(trimmed[2:]))
+			cell = nil
+		case strings.HasPrefix(trimmed, "|-"):
+			newRow()
+			cell = nil
+		case strings.HasPrefix(trimmed, "!"):
+			for _, text := range splitCells(trimmed[1:], true) {
+				addCell(true, text)
+			}
+		case strings.HasPrefix(trimmed, "|"):
+			for _, text := range splitCells(trimmed[1:], false) {
+				addCell(false, text)
+			}
+		case cell != nil:
+			cell.content = append(cell.content, line)
+		}
+	}
+
+	b.WriteString("<table>\n")
+	if len(caption) > 0 {
+		b.WriteString("<caption>" + renderInline(strings.Join(caption, " "), linkBase) + "</caption>\n")
+	}
+	for _, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		b.WriteString("<tr>")
+		for _, cell := range row {
+			tag := "td"
+			if cell.header {
+				tag = "th"
+			}
+			b.WriteString("<" + tag + cellAttrs(cell.attrs) + ">")
+			content := strings.TrimSpace(strings.Join(cell.content, "\n"))
+			if strings.Contains(content, "\n") {
+				b.WriteString(renderWikitext(content, linkBase))
+			} else {
+				b.WriteString(renderInline(content, linkBase))
+			}
+			b.WriteString("</" + tag + ">")
+		}
+		b.WriteString("</tr>\n")
+	}
+	b.WriteString("</table>\n")
+	return b.String()
+}
+
+// splitCells splits a table line into its cells which are separated by ||
+// and for header lines also by !!
+func splitCells(line string, header bool) []string {
+	var cells []string
+	depth, start := 0, 0
+	for i := 0; i+1 < len(line); i++ {
+		switch {
+		case strings.HasPrefix(line[i:], "[["), strings.HasPrefix(line[i:], "{{"):
+			depth++
+			i++
+		case strings.HasPrefix(line[i:], "]]"), strings.HasPrefix(line[i:], "}}"):
+			depth--
+			i++
+		case depth == 0 && (strings.HasPrefix(line[i:], "||") || header && strings.HasPrefix(line[i:], "!!")):
+			cells = append(cells, line[start:i])
+			start = i + 2
+			i++
+		}
+	}
+	return append(cells, line[start:])
+}
+
+// splitCellAttrs splits a cell like style="..."|value into its attributes
+// and content. Pipes inside links or templates don't separate attributes.
+func splitCellAttrs(text string) (attrs, content string, ok bool) {
+	i := strings.Index(text, "|")
+	if i < 0 {
+		return "", text, false
+	}
+	attrs = text[:i]
+	if strings.Contains(attrs, "[[") || strings.Contains(attrs, "{{") {
+		return "", text, false
+	}
+	return attrs, text[i+1:], true
+}
+
+// cellAttrs returns the HTML attributes kept from the wikitext attributes of
+// a cell
+func cellAttrs(attrs string) string {
+	var b strings.Builder
+	for _, m := range spanRegexp.FindAllStringSubmatch(attrs, -1) {
+		b.WriteString(" " + strings.ToLower(m[1]) + `="` + m[2] + `"`)
+	}
+	return b.String()
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestRenderTable(t *testing.T) {
+	const wikitext = `{| class="wikitable"
+|+ Planets
+! Name !! Moons
+|-
+| [[Mercury (planet)|Mercury]] || 0
+|-
+! scope="row" | Earth
+| style="color:red" | 1
+|-
+| colspan="2" style="x" | ''Total'' 1
+|}`
+	want := "<table>\n<caption>Planets</caption>\n" +
+		"<tr><th>Name</th><th>Moons</th></tr>\n" +
+		`<tr><td><a href="/wiki/Mercury_%28planet%29">Mercury</a></td><td>0</td></tr>` + "\n" +
+		"<tr><th>Earth</th><td>1</td></tr>\n" +
+		`<tr><td colspan="2"><i>Total</i> 1</td></tr>` + "\n" +
+		"</table>\n"
+	if got := renderWikitext(wikitext, defaultLinkBase); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSplitCellAttrs(t *testing.T) {
+	tests := []struct {
+		text, attrs, content string
+		ok                   bool
+	}{
+		{`style="color:red" | 1`, `style="color:red" `, " 1", true},
+		{`rowspan=2|x`, "rowspan=2", "x", true},
+		{"no attributes", "", "no attributes", false},
+		{"[[A|B]]", "", "[[A|B]]", false},
+		{"{{lang|en|x}}", "", "{{lang|en|x}}", false},
+	}
+	for _, tt := range tests {
+		attrs, content, ok := splitCellAttrs(tt.text)
+		if attrs != tt.attrs || content != tt.content || ok != tt.ok {
+			t.Errorf("splitCellAttrs(%q) = %q, %q, %v, want %q, %q, %v", tt.text, attrs, content, ok, tt.attrs, tt.content, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+)
+
+// templateExpander renders a template to wikitext given its arguments. Named
+// arguments are passed as name=value.
+type templateExpander func(args []string) string
+
+// templateExpanders maps lower case template names to their expanders.
+// Templates not listed here are left alone by expandTemplates.
+var templateExpanders = map[string]templateExpander{
+	"convert": expandConvert,
+	"cvt":     expandConvert,
+	"lang":    positionalArg(1),
+	"nowrap":  positionalArg(0),
+	"small":   positionalArg(0),
+	"abbr":    positionalArg(0),
+	"sic":     positionalArg(0),
+	"nbsp":    literal(" "),
+	"ndash":   literal("–"),
+	"mdash":   literal("—"),
+}
+
+// registerTemplate makes expandTemplates render the template name with
+// expand. It must be called before serving, e.g. from an init function.
+func registerTemplate(name string, expand templateExpander) {
+	templateExpanders[normalizeTemplateName(name)] = expand
+}
+
+func normalizeTemplateName(name string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Replace(name, "_", " ", -1)))
+}
+
+// expandTemplates replaces all templates with a registered expander by their
+// expansion. Nested templates are expanded first so their output is seen by
+// the enclosing one.
+func expandTemplates(wikitext string) string {
+	start := strings.Index(wikitext, "{{")
+	if start < 0 {
+		return wikitext
+	}
+	var b strings.Builder
+	text := wikitext
+	templates := newDelimiterScanner("{{", "}}")
+	for start >= 0 {
+		b.WriteString(text[:start])
+		text = text[start:]
+		end := templates.end(text)
+		if end < 0 {
+			// An unclosed template may still contain closed ones
+			b.WriteString("{{")
+			text = text[2:]
+			start = strings.Index(text, "{{")
+			continue
+		}
+		inner := expandTemplates(text[2 : end-2])
+		params := splitTemplateParams(inner)
+		if expand, ok := templateExpanders[normalizeTemplateName(params[0])]; ok {
+			args := make([]string, len(params)-1)
+			for i, param := range params[1:] {
+				args[i] = strings.TrimSpace(param)
+			}
+			b.WriteString(expand(args))
+		} else {
+			b.WriteString("{{" + inner + "}}")
+		}
+		text = text[end:]
+		start = strings.Index(text, "{{")
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+// positional drops the named arguments
+func positional(args []string) []string {
+	var result []string
+	for _, arg := range args {
+		if name, _, ok := strings.Cut(arg, "="); ok && !strings.ContainsAny(name, "[{ ") {
+			continue
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+// positionalArg returns an expander rendering the i-th positional argument
+func positionalArg(i int) templateExpander {
+	return func(args []string) string {
+		args = positional(args)
+		if i >= len(args) {
+			return ""
+		}
+		return args[i]
+	}
+}
+
+func literal(text string) templateExpander {
+	return func([]string) string {
+		return text
+	}
+}
+
+// expandConvert renders {{convert|5|km|mi}} as "5 km" and ranges like
+// {{convert|5|to|10|km}} as "5 to 10 km" without converting anything.
+func expandConvert(args []string) string {
+	args = positional(args)
+	if len(args) == 0 {
+		return ""
+	}
+	value := args[0]
+	rest := args[1:]
+	if len(rest) >= 2 {
+		switch rest[0] {
+		case "to", "and", "or", "-", "–", "by", "x", "×":
+			separator := " " + rest[0] + " "
+			if rest[0] == "-" || rest[0] == "–" {
+				separator = "–"
+			}
+			value += separator + rest[1]
+			rest = rest[2:]
+		}
+	}
+	if len(rest) == 0 {
+		return value
+	}
+	return value + " " + rest[0]
+}
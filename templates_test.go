@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestExpandTemplates(t *testing.T) {
+	tests := []struct {
+		name, wikitext, want string
+	}{
+		{"convert", "It is {{convert|5|km|mi}} long.", "It is 5 km long."},
+		{"convert with named argument", "{{cvt|5|km|abbr=on}}", "5 km"},
+		{"convert range", "{{convert|5|to|10|km}}", "5 to 10 km"},
+		{"convert dash range", "{{convert|5|-|10|km|mi}}", "5–10 km"},
+		{"convert without unit", "{{convert|5}}", "5"},
+		{"lang", "{{lang|fr|''éclair''}}", "''éclair''"},
+		{"lang with link", "{{Lang|de|[[Berlin|Stadt]]}}", "[[Berlin|Stadt]]"},
+		{"nested", "{{nowrap|{{convert|3|m}}}}", "3 m"},
+		{"literal", "1{{ndash}}2", "1–2"},
+		{"underscores and case", "{{Mdash}}{{no_wrap|x}}", "—{{no_wrap|x}}"},
+		{"unknown kept", "{{Infobox|a={{convert|1|kg}}}}", "{{Infobox|a=1 kg}}"},
+		{"unclosed", "{{lang|en|x {{ndash}}", "{{lang|en|x –"},
+		{"missing argument", "{{lang|fr}}", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expandTemplates(tt.wikitext); got != tt.want {
+				t.Errorf("expandTemplates(%q) = %q, want %q", tt.wikitext, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterTemplate(t *testing.T) {
+	registerTemplate("Shout_Out", func(args []string) string { return args[0] + "!" })
+	defer delete(templateExpanders, "shout out")
+	if got := expandTemplates("{{shout out|hi}}"); got != "hi!" {
+		t.Errorf("got %q, want %q", got, "hi!")
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+// stripWikitext removes all markup from wikitext leaving only the prose.
+// Templates without an expander, tables, references, comments, files and
+// categories are dropped entirely while links are replaced by their displayed
+// text.
+func stripWikitext(wikitext string) string {
+	var b strings.Builder
+	text := expandTemplates(wikitext)
+	templates := newDelimiterScanner("{{", "}}")
+	tables := newDelimiterScanner("{|", "|}")
+	links := newDelimiterScanner("[[", "]]")
+	for len(text) > 0 {
+		switch {
+		case strings.HasPrefix(text, "<!--"):
+			end := strings.Index(text, "-->")
+			if end < 0 {
+				return finishStrip(b.String())
+			}
+			text = text[end+3:]
+		case strings.HasPrefix(text, "{{"):
+			text = templates.skip(text)
+		case strings.HasPrefix(text, "{|"):
+			text = tables.skip(text)
+		case isRefTag(text):
+			text = skipRef(text)
+		case strings.HasPrefix(text, "[["):
+			end := links.end(text)
+			if end < 0 {
+				b.WriteString("[[")
+				text = text[2:]
+				continue
+			}
+			display, drop := linkText(text[2 : end-2])
+			b.WriteString(display)
+			text = text[end:]
+			if drop && strings.HasPrefix(text, "\n") && lineStart(b.String()) {
+				text = text[1:]
+			}
+		default:
+			next := strings.IndexAny(text[1:], "<{[")
+			if next < 0 {
+				next = len(text) - 1
+			}
+			b.WriteString(text[:next+1])
+			text = text[next+1:]
+		}
+	}
+	return finishStrip(b.String())
+}
+
+// finishStrip removes the line based markup of headings, lists and bold or
+// italic text once all nested constructs are gone.
+func finishStrip(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if m := headingRegexp.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			line = m[2]
+		}
+		if stripped := strings.TrimLeft(line, "*#:;"); stripped != line {
+			line = strings.TrimSpace(stripped)
+		}
+		line = strings.Replace(line, "'''", "", -1)
+		line = strings.Replace(line, "''", "", -1)
+		lines[i] = decodeEntities(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// normalizeWhitespace tidies up the gaps removed markup leaves in plain text.
+// Trailing spaces are trimmed from every line, runs of blank lines collapse
+// into a single paragraph break and blank lines at the start and end are
+// dropped.
+func normalizeWhitespace(text string) string {
+	var b strings.Builder
+	blank := false
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			blank = b.Len() > 0
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+			if blank {
+				b.WriteByte('\n')
+			}
+		}
+		b.WriteString(line)
+		blank = false
+	}
+	return b.String()
+}
+
+// wordsPerMinute is the reading speed reading times are estimated with
+const wordsPerMinute = 200
+
+// textLength counts the words and characters of the plain text of wikitext
+// and estimates how many minutes reading it takes, at least one unless
+// there are no words
+func textLength(wikitext string) (words, characters, minutes int) {
+	text := normalizeWhitespace(stripWikitext(wikitext))
+	words = len(strings.Fields(text))
+	minutes = (words + wordsPerMinute - 1) / wordsPerMinute
+	return words, utf8.RuneCountInString(text), minutes
+}
+
+// decodeEntities replaces named, decimal and hexadecimal HTML entities like
+// &amp;, &#39; and &#x27; by the characters they stand for.
+func decodeEntities(text string) string {
+	return html.UnescapeString(text)
+}
+
+// linkText returns the displayed text of the internal link inside [[...]].
+// Links to files and categories don't display any text, for these drop is
+// true.
+func linkText(link string) (display string, drop bool) {
+	target := link
+	if i := strings.Index(link, "|"); i >= 0 {
+		target = link[:i]
+	}
+	if isFileOrCategory(target) {
+		return "", true
+	}
+	if i := strings.Index(link, "|"); i >= 0 {
+		if strings.TrimSpace(link[i+1:]) == "" {
+			return pipeTrick(target), false
+		}
+		return link[i+1:], false
+	}
+	return strings.TrimPrefix(target, ":"), false
+}
+
+func isFileOrCategory(target string) bool {
+	for _, namespace := range []string{"File", "Image", "Category"} {
+		if _, ok := cutNamespace(target, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// cutNamespace returns the title of target without its namespace prefix and
+// whether target is in namespace at all. Like MediaWiki it ignores case and
+// spaces or underscores around the colon, so [[Category :Foo|*]] is in the
+// Category namespace as well. Targets starting with a colon are in none.
+func cutNamespace(target, namespace string) (string, bool) {
+	prefix, title, ok := strings.Cut(target, ":")
+	if !ok || !strings.EqualFold(strings.Trim(prefix, " _"), namespace) {
+		return "", false
+	}
+	return strings.Trim(title, " _"), true
+}
+
+// balancedEnd returns the index just past the close delimiter matching the
+// open delimiter at the start of text or -1 if it is never closed.
+func balancedEnd(text, open, close string) int {
+	return newDelimiterScanner(open, close).end(text)
+}
+
+// delimiterScanner finds the ends of constructs like balancedEnd, but in
+// successive suffixes of one text. The opens a failed scan leaves unclosed are
+// remembered by the length of the text from them on, so a scan starting at
+// one of them later on fails right away. This keeps pages with many unclosed
+// delimiters from being scanned to their end over and over again.
+type delimiterScanner struct {
+	open, close string
+	unclosed    map[int]bool
+}
+
+func newDelimiterScanner(open, close string) *delimiterScanner {
+	return &delimiterScanner{open, close, make(map[int]bool)}
+}
+
+// end returns the index just past the close delimiter matching the open
+// delimiter at the start of text or -1 if it is never closed
+func (s *delimiterScanner) end(text string) int {
+	if s.unclosed[len(text)] {
+		return -1
+	}
+	// The opens not closed yet by the length of the text from them on
+	var opens []int
+	for i := 0; i < len(text); {
+		switch {
+		case strings.HasPrefix(text[i:], s.open):
+			opens = append(opens, len(text)-i)
+			i += len(s.open)
+		case strings.HasPrefix(text[i:], s.close) && len(opens) > 0:
+			opens = opens[:len(opens)-1]
+			i += len(s.close)
+			if len(opens) == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	for _, rest := range opens {
+		s.unclosed[rest] = true
+	}
+	return -1
+}
+
+// skip drops the construct at the start of text. An unbalanced open
+// delimiter is dropped on its own so the following text survives.
+func (s *delimiterScanner) skip(text string) string {
+	end := s.end(text)
+	if end < 0 {
+		return text[len(s.open):]
+	}
+	return text[end:]
+}
+
+// skipRef drops the <ref> tag at the start of text including its content
+// unless it is self closing.
+func skipRef(text string) string {
+	tagEnd := strings.Index(text, ">")
+	if tagEnd < 0 {
+		return text[len("<ref"):]
+	}
+	if text[tagEnd-1] == '/' {
+		return text[tagEnd+1:]
+	}
+	closeStart := indexFold(text, "</ref>")
+	if closeStart < 0 {
+		return text[tagEnd+1:]
+	}
+	return text[closeStart+len("</ref>"):]
+}
+
+func isRefTag(text string) bool {
+	if !hasPrefixFold(text, "<ref") || len(text) == len("<ref") {
+		return false
+	}
+	switch text[len("<ref")] {
+	case ' ', '>', '/':
+		return true
+	}
+	return false
+}
+
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func lineStart(s string) bool {
+	return len(s) == 0 || s[len(s)-1] == '\n'
+}
+
+// leadSection returns the wikitext before the first heading with the
+// templates, comments and files leading up to the prose, like infoboxes or
+// hatnotes, removed.
+func leadSection(wikitext string) string {
+	text := wikitext
+	templates := newDelimiterScanner("{{", "}}")
+	for {
+		text = strings.TrimLeft(text, " \t\n")
+		switch {
+		case strings.HasPrefix(text, "{{"):
+			text = templates.skip(text)
+		case strings.HasPrefix(text, "<!--"):
+			end := strings.Index(text, "-->")
+			if end < 0 {
+				return ""
+			}
+			text = text[end+3:]
+		case strings.HasPrefix(text, "[["):
+			end := balancedEnd(text, "[[", "]]")
+			if end < 0 {
+				return cutAtHeading(text)
+			}
+			if _, drop := linkText(text[2 : end-2]); !drop {
+				return cutAtHeading(text)
+			}
+			text = text[end:]
+		default:
+			return cutAtHeading(text)
+		}
+	}
+}
+
+// cutAtHeading returns the text before the first heading line
+func cutAtHeading(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if headingRegexp.MatchString(strings.TrimSpace(line)) {
+			return strings.TrimSpace(strings.Join(lines[:i], "\n"))
+		}
+	}
+	return strings.TrimSpace(text)
+}
+
+// extractSection returns the section with the given heading including the
+// heading line and its subsections. Headings are matched case insensitively.
+func extractSection(wikitext, name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	lines := strings.Split(wikitext, "\n")
+	start, level := -1, 0
+	for i, line := range lines {
+		m := headingRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		lineLevel := min(len(m[1]), len(m[3]))
+		if start >= 0 && lineLevel <= level {
+			return strings.Join(lines[start:i], "\n"), true
+		}
+		if start < 0 && strings.EqualFold(strings.TrimSpace(m[2]), name) {
+			start, level = i, lineLevel
+		}
+	}
+	if start < 0 {
+		return "", false
+	}
+	return strings.Join(lines[start:], "\n"), true
+}
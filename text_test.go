@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStripWikitextUnclosed(t *testing.T) {
+	tests := []struct {
+		name, wikitext, want string
+	}{
+		{"closed inside unclosed template", "{{ {{lang|en|x}} tail", "x tail"},
+		{"unclosed link", "a [[b c", "a [[b c"},
+		{"unclosed table", "a {| b", "a  b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.TrimSpace(stripWikitext(tt.wikitext)); got != tt.want {
+				t.Errorf("stripWikitext(%q) = %q, want %q", tt.wikitext, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnclosedDelimitersScanOnce(t *testing.T) {
+	wikitext := strings.Repeat("[[a {{b {| ", 20000)
+	start := time.Now()
+	stripWikitext(wikitext)
+	leadSection(wikitext)
+	eachLink(wikitext, func(string) {})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %v on %d bytes of unclosed delimiters", elapsed, len(wikitext))
+	}
+}
+
+func TestCutNamespace(t *testing.T) {
+	tests := []struct {
+		target, namespace, title string
+		ok                       bool
+	}{
+		{"Category:Physicists", "Category", "Physicists", true},
+		{"Category :Physicists", "Category", "Physicists", true},
+		{" category_: Physicists ", "Category", "Physicists", true},
+		{"CATEGORY:Physicists", "Category", "Physicists", true},
+		{":Category:Physicists", "Category", "", false},
+		{"Categories:Physicists", "Category", "", false},
+		{"Physicists", "Category", "", false},
+		{"File : Turing.jpg", "File", "Turing.jpg", true},
+	}
+	for _, tt := range tests {
+		title, ok := cutNamespace(tt.target, tt.namespace)
+		if title != tt.title || ok != tt.ok {
+			t.Errorf("cutNamespace(%q, %q) = %q, %v, want %q, %v", tt.target, tt.namespace, title, ok, tt.title, tt.ok)
+		}
+	}
+	for _, target := range []string{"File : Turing.jpg", "Image_:x.png", "Category :Foo"} {
+		if !isFileOrCategory(target) {
+			t.Errorf("isFileOrCategory(%q) = false", target)
+		}
+	}
+}
+
+func TestLeadSection(t *testing.T) {
+	tests := []struct {
+		name, wikitext, want string
+	}{
+		{"plain", "First paragraph.\n\nSecond paragraph.\n== History ==\nLater.", "First paragraph.\n\nSecond paragraph."},
+		{"infobox", "{{Infobox person\n| name = A\n| born = {{birth date|1912|6|23}}\n}}\n'''A''' was a person.\n== Life ==", "'''A''' was a person."},
+		{"hatnotes and comments", "{{about|the person}}\n<!-- lead -->\n{{Use dmy dates}}\nText.", "Text."},
+		{"leading file", "[[File:A.jpg|thumb|A [[B]] caption]]\nText.", "Text."},
+		{"leading link kept", "[[Alan Turing]] was a mathematician.", "[[Alan Turing]] was a mathematician."},
+		{"heading first", "== Only a section ==\nText.", ""},
+		{"unclosed comment", "<!-- never closed\nText.", ""},
+		{"no heading", "  Text without sections.  ", "Text without sections."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := leadSection(tt.wikitext); got != tt.want {
+				t.Errorf("leadSection(%q) = %q, want %q", tt.wikitext, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeWhitespace(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{"paragraphs kept", "One.\n\nTwo.\nStill two.", "One.\n\nTwo.\nStill two."},
+		{"blank lines collapsed", "One.\n\n\n\n\nTwo.", "One.\n\nTwo."},
+		{"whitespace only lines", "One.\n  \n\t\n \nTwo.", "One.\n\nTwo."},
+		{"trailing spaces", "One.  \t\nTwo. ", "One.\nTwo."},
+		{"leading spaces kept", "One.\n  Indented.", "One.\n  Indented."},
+		{"blank lines around", "\n\n \nOne.\n\n\n", "One."},
+		{"empty", " \n\n ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeWhitespace(tt.text); got != tt.want {
+				t.Errorf("normalizeWhitespace(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+
+	// Removed templates and categories leave gaps behind
+	rec := get(wikiRoute(newTestHandler(t)), "/wiki/Alan_Turing?format=text")
+	if body := rec.Body.String(); strings.Contains(body, "\n\n\n") || strings.Contains(body, " \n") || strings.TrimSpace(body) != body {
+		t.Errorf("got %q", body)
+	}
+}
+
+func TestTextLength(t *testing.T) {
+	tests := []struct {
+		wikitext                   string
+		words, characters, minutes int
+	}{
+		{"", 0, 0, 0},
+		{"{{Infobox|name=A}}\n[[Category:B]]", 0, 0, 0},
+		// Markup isn't counted, neither are link targets or references
+		{"'''One''' [[two|three]] four<ref>five six</ref>", 3, 14, 1},
+		{"Crème brûlée", 2, 12, 1},
+	}
+	for _, tt := range tests {
+		words, characters, minutes := textLength(tt.wikitext)
+		if words != tt.words || characters != tt.characters || minutes != tt.minutes {
+			t.Errorf("textLength(%q) = %d, %d, %d, want %d, %d, %d", tt.wikitext, words, characters, minutes, tt.words, tt.characters, tt.minutes)
+		}
+	}
+	// The reading time grows by a minute with every wordsPerMinute words
+	for _, words := range []int{1, wordsPerMinute, wordsPerMinute + 1, 5 * wordsPerMinute, 5*wordsPerMinute + 1} {
+		gotWords, _, minutes := textLength(strings.Repeat("word ", words))
+		if want := (words + wordsPerMinute - 1) / wordsPerMinute; gotWords != words || minutes != want {
+			t.Errorf("%d words: got %d words and %d minutes, want %d minutes", words, gotWords, minutes, want)
+		}
+	}
+
+	var meta metaJSON
+	rec := get(apiRoutes(newTestHandler(t)), "/api/meta/Alan_Turing")
+	if err := json.Unmarshal(rec.Body.Bytes(), &meta); err != nil {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+	if meta.Words < 30 || meta.Words > 60 || meta.Characters < 5*meta.Words || meta.Characters > 8*meta.Words || meta.ReadingMinutes != 1 {
+		t.Errorf("Alan Turing: got %d words, %d characters and %d minutes", meta.Words, meta.Characters, meta.ReadingMinutes)
+	}
+}
+
+func TestDecodeEntities(t *testing.T) {
+	tests := []struct {
+		wikitext, text, html string
+	}{
+		{"A &amp; B", "A & B", "<p>A &amp; B</p>\n"},
+		{"it&#39;s &#x27;quoted&#x27;", "it's 'quoted'", "<p>it&#39;s &#39;quoted&#39;</p>\n"},
+		{"1&nbsp;km &mdash; far", "1 km — far", "<p>1 km — far</p>\n"},
+		{"&lt;b&gt;not bold&lt;/b&gt;", "<b>not bold</b>", "<p>&lt;b&gt;not bold&lt;/b&gt;</p>\n"},
+		{"&bogus; &amp", "&bogus; &", "<p>&amp;bogus; &amp;</p>\n"},
+		{"[[AT&amp;T|AT&amp;T Inc.]]", "AT&T Inc.", "<p><a href=\"/wiki/AT&amp;T\">AT&amp;T Inc.</a></p>\n"},
+	}
+	for _, tt := range tests {
+		if got := strings.TrimSpace(stripWikitext(tt.wikitext)); got != tt.text {
+			t.Errorf("stripWikitext(%q) = %q, want %q", tt.wikitext, got, tt.text)
+		}
+		if got := renderWikitext(tt.wikitext, defaultLinkBase); got != tt.html {
+			t.Errorf("renderWikitext(%q) = %q, want %q", tt.wikitext, got, tt.html)
+		}
+	}
+
+	rec := get(http.StripPrefix("/text/", http.HandlerFunc(newTestHandler(t).ServeText)), "/text/Alan_Turing")
+	if !strings.Contains(rec.Body.String(), "mathematician & computer scientist") {
+		t.Errorf("plain text of Alan Turing: got %q, want the &amp; decoded", rec.Body.String())
+	}
+}
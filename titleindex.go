@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// titleIndex maps titles to the location of their page in the dump and also
+// allows walking all titles in sorted order.
+type titleIndex interface {
+	Lookup(title string) (OffsetAndId, bool)
+	Len() int
+	// Title returns the i-th title in sorted order
+	Title(i int) string
+}
+
+// newTitleIndex builds the index of the given kind, either "map" or "sorted",
+// from an offset map.
+func newTitleIndex(kind string, offsetMap map[string]OffsetAndId) (titleIndex, error) {
+	switch kind {
+	case "map":
+		return newMapIndex(offsetMap), nil
+	case "sorted":
+		return newSortedIndex(offsetMap)
+	default:
+		return nil, fmt.Errorf("unknown index kind %q", kind)
+	}
+}
+
+// searchTitles returns the position of the first title not sorting before
+// prefix
+func searchTitles(index titleIndex, prefix string) int {
+	return sort.Search(index.Len(), func(i int) bool {
+		return index.Title(i) >= prefix
+	})
+}
+
+// mapIndex looks titles up in a hash map, it needs more memory than
+// sortedIndex but lookups take constant time.
+type mapIndex struct {
+	offsetMap map[string]OffsetAndId
+	titles    []string
+}
+
+func newMapIndex(offsetMap map[string]OffsetAndId) *mapIndex {
+	titles := make([]string, 0, len(offsetMap))
+	for title := range offsetMap {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	return &mapIndex{offsetMap, titles}
+}
+
+func (m *mapIndex) Lookup(title string) (OffsetAndId, bool) {
+	offsetAndId, ok := m.offsetMap[title]
+	return offsetAndId, ok
+}
+
+func (m *mapIndex) Len() int {
+	return len(m.titles)
+}
+
+func (m *mapIndex) Title(i int) string {
+	return m.titles[i]
+}
+
+type sortedIndexEntry struct {
+	Title  string
+	Offset int64
+	Id     uint32
+}
+
+// sortedIndex keeps compact entries sorted by title and finds them with a
+// binary search.
+type sortedIndex []sortedIndexEntry
+
+func newSortedIndex(offsetMap map[string]OffsetAndId) (sortedIndex, error) {
+	index := make(sortedIndex, 0, len(offsetMap))
+	for title, offsetAndId := range offsetMap {
+		if offsetAndId.Id > math.MaxUint32 {
+			return nil, fmt.Errorf("id %d of %q is too large for a sorted index", offsetAndId.Id, title)
+		}
+		index = append(index, sortedIndexEntry{title, offsetAndId.Offset, uint32(offsetAndId.Id)})
+	}
+	sort.Slice(index, func(i, j int) bool {
+		return index[i].Title < index[j].Title
+	})
+	return index, nil
+}
+
+func (s sortedIndex) Lookup(title string) (OffsetAndId, bool) {
+	i := sort.Search(len(s), func(i int) bool {
+		return s[i].Title >= title
+	})
+	if i == len(s) || s[i].Title != title {
+		return OffsetAndId{}, false
+	}
+	return OffsetAndId{s[i].Offset, uint64(s[i].Id)}, true
+}
+
+func (s sortedIndex) Len() int {
+	return len(s)
+}
+
+func (s sortedIndex) Title(i int) string {
+	return s[i].Title
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// testOffsetMap returns n titles in streams of 100 pages
+func testOffsetMap(n int) map[string]OffsetAndId {
+	offsetMap := make(map[string]OffsetAndId, n)
+	for i := 0; i < n; i++ {
+		offsetMap[fmt.Sprintf("Title %07d", i)] = OffsetAndId{int64(1000 * (i / 100)), uint64(i + 1)}
+	}
+	return offsetMap
+}
+
+func TestIndexKindsAgree(t *testing.T) {
+	offsetMap := testOffsetMap(1000)
+	offsetMap["Éclair"] = OffsetAndId{5, 1 << 31}
+	for _, kind := range []string{"map", "sorted"} {
+		index, err := newTitleIndex(kind, offsetMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index.Len() != len(offsetMap) {
+			t.Errorf("%s: Len() = %d, want %d", kind, index.Len(), len(offsetMap))
+		}
+		for title, want := range offsetMap {
+			if got, ok := index.Lookup(title); !ok || got != want {
+				t.Errorf("%s: Lookup(%q) = %v, %v, want %v", kind, title, got, ok, want)
+			}
+		}
+		if _, ok := index.Lookup("Title"); ok {
+			t.Errorf("%s: found the missing title Title", kind)
+		}
+		for i := 1; i < index.Len(); i++ {
+			if index.Title(i-1) >= index.Title(i) {
+				t.Errorf("%s: titles %d and %d out of order", kind, i-1, i)
+			}
+		}
+	}
+}
+
+// BenchmarkIndexLookup measures lookups in an index of 200000 titles and
+// reports the heap the loaded wiki takes up in bytes, the index along with
+// everything newWikiData derives from it once the offset map it was built
+// from is gone, like after loading
+func BenchmarkIndexLookup(b *testing.B) {
+	const n = 200000
+	titles := make([]string, n)
+	for i := range titles {
+		titles[i] = fmt.Sprintf("Title %07d", i)
+	}
+	for _, kind := range []string{"map", "sorted"} {
+		b.Run(kind, func(b *testing.B) {
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			index, err := newTitleIndex(kind, testOffsetMap(n))
+			if err != nil {
+				b.Fatal(err)
+			}
+			data := newWikiData(index, dumpSource{path: testContentPath}, 0, 0)
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				data.index.Lookup(titles[i%n])
+			}
+			b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc)), "index-bytes")
+			runtime.KeepAlive(data)
+		})
+	}
+}
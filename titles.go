@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// normalizeTitle converts a title as it appears in Wikipedia URLs into the form
+// used by the multistream index, i.e. with spaces instead of underscores and
+// an upper case first letter. Like MediaWiki it drops surrounding spaces and
+// collapses runs of them.
+func normalizeTitle(title string) string {
+	title = collapseSpaces(title)
+	first, size := utf8.DecodeRuneInString(title)
+	if first == utf8.RuneError {
+		return title
+	}
+	return string(unicode.ToUpper(first)) + title[size:]
+}
+
+// collapseSpaces replaces the underscores of title with spaces, drops those
+// surrounding it and collapses runs of them into one
+func collapseSpaces(title string) string {
+	words := strings.FieldsFunc(title, func(r rune) bool {
+		return r == ' ' || r == '_'
+	})
+	return strings.Join(words, " ")
+}
+
+// normalizePrefix is normalizeTitle for the start of a title. A trailing
+// space is kept since "New " shouldn't complete to Newark.
+func normalizePrefix(prefix string) string {
+	return keepTrailingSpace(prefix, normalizeTitle(prefix))
+}
+
+// keepTrailingSpace appends a space to the non-empty cleaned version of
+// prefix if prefix ended in a space or underscore
+func keepTrailingSpace(prefix, cleaned string) string {
+	if cleaned != "" && strings.TrimRight(prefix, " _") != prefix {
+		cleaned += " "
+	}
+	return cleaned
+}
+
+// findTitle finds rawTitle in the index. As on Wikipedia only the first
+// letter of a title is case insensitive: the title is tried as given, with
+// underscores as spaces, so titles of case sensitive wikis like Wiktionary
+// starting with a lowercase letter are found, and then normalized. It
+// returns the title that was found or the normalized one if neither was.
+func findTitle(index titleIndex, rawTitle string) (string, OffsetAndId, bool) {
+	exact := collapseSpaces(rawTitle)
+	if offsetAndId, ok := index.Lookup(exact); ok {
+		return exact, offsetAndId, true
+	}
+	title := normalizeTitle(rawTitle)
+	offsetAndId, ok := index.Lookup(title)
+	return title, offsetAndId, ok
+}
+
+// maxTitleBytes is the length limit MediaWiki puts on titles
+const maxTitleBytes = 255
+
+var (
+	errTitleTooLong     = errors.New("title too long")
+	errTitleControlChar = errors.New("title contains control characters")
+	errTitleIllegalChar = errors.New("title contains one of < > [ ] | { }")
+	errTitleRelative    = errors.New("title is a relative path")
+)
+
+// validateTitle rejects titles no article can have so requests for them are
+// answered without a lookup. These are the rules MediaWiki applies to new
+// titles.
+func validateTitle(title string) error {
+	if len(title) > maxTitleBytes {
+		return errTitleTooLong
+	}
+	if strings.ContainsFunc(title, unicode.IsControl) {
+		return errTitleControlChar
+	}
+	if strings.ContainsAny(title, "<>[]|{}") {
+		return errTitleIllegalChar
+	}
+	if title == "." || title == ".." || strings.HasPrefix(title, "./") || strings.HasPrefix(title, "../") ||
+		strings.Contains(title, "/./") || strings.Contains(title, "/../") ||
+		strings.HasSuffix(title, "/.") || strings.HasSuffix(title, "/..") {
+		return errTitleRelative
+	}
+	return nil
+}
+
+// titlesById maps the page ids of the index back to their titles
+func titlesById(index titleIndex) map[uint64]string {
+	byId := make(map[uint64]string, index.Len())
+	for i := 0; i < index.Len(); i++ {
+		title := index.Title(i)
+		offsetAndId, _ := index.Lookup(title)
+		byId[offsetAndId.Id] = title
+	}
+	return byId
+}
+
+// completeTitles returns up to limit titles starting with prefix
+func completeTitles(index titleIndex, prefix string, limit int) []string {
+	matches := make([]string, 0, limit)
+	for i := searchTitles(index, prefix); i < index.Len() && len(matches) < limit; i++ {
+		title := index.Title(i)
+		if !strings.HasPrefix(title, prefix) {
+			break
+		}
+		matches = append(matches, title)
+	}
+	return matches
+}
+
+// completePrefix returns up to limit titles starting with rawPrefix in index
+// order. Like findTitle it tries the prefix as given, with underscores as
+// spaces, and normalized, so titles starting with a lowercase letter like
+// eBay are completed as well. The two only differ in the case of their
+// first letter, so no title matches both.
+func completePrefix(index titleIndex, rawPrefix string, limit int) []string {
+	normalized := normalizePrefix(rawPrefix)
+	matches := completeTitles(index, normalized, limit)
+	asGiven := keepTrailingSpace(rawPrefix, collapseSpaces(rawPrefix))
+	if asGiven == normalized {
+		return matches
+	}
+	matches = append(completeTitles(index, asGiven, limit), matches...)
+	sort.Strings(matches)
+	return matches[:min(len(matches), limit)]
+}
+
+// containingTitles returns up to limit titles containing query regardless of
+// case, titles starting with it first. lower are the titles of index
+// lowercased. The scan stops once limit titles start with query.
+func containingTitles(index titleIndex, lower []string, query string, limit int) []string {
+	query = strings.ToLower(query)
+	prefixed := make([]string, 0, limit)
+	var containing []string
+	for i, title := range lower {
+		if len(prefixed) == limit {
+			break
+		}
+		switch pos := strings.Index(title, query); {
+		case pos == 0:
+			prefixed = append(prefixed, index.Title(i))
+		case pos > 0 && len(containing) < limit:
+			containing = append(containing, index.Title(i))
+		}
+	}
+	return append(prefixed, containing[:min(len(containing), limit-len(prefixed))]...)
+}
+
+const (
+	// maxSuggestions is the number of titles suggested for a missing one
+	maxSuggestions = 5
+	// suggestionWindow is the number of titles sorting before and after a
+	// missing title that are considered as suggestions
+	suggestionWindow = 500
+)
+
+// suggestTitles returns up to maxSuggestions titles close to the one that
+// couldn't be found. To stay fast only titles that sort near title or near its
+// first half are compared, which catches most misspellings not right at the
+// start.
+func suggestTitles(index titleIndex, title string) []string {
+	type candidate struct {
+		title    string
+		distance int
+	}
+	maxDistance := utf8.RuneCountInString(title) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	seen := make(map[string]bool)
+	var candidates []candidate
+	for _, probe := range []string{title, firstHalf(title)} {
+		pos := searchTitles(index, probe)
+		lo, hi := max(pos-suggestionWindow, 0), min(pos+suggestionWindow, index.Len())
+		for i := lo; i < hi; i++ {
+			t := index.Title(i)
+			if seen[t] {
+				continue
+			}
+			seen[t] = true
+			if d := editDistance(strings.ToLower(t), strings.ToLower(title)); d <= maxDistance {
+				candidates = append(candidates, candidate{t, d})
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].title < candidates[j].title
+	})
+	suggestions := make([]string, 0, maxSuggestions)
+	for _, c := range candidates {
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+		suggestions = append(suggestions, c.title)
+	}
+	return suggestions
+}
+
+// firstHalf returns the first half of title, cut before the rune its middle
+// falls into
+func firstHalf(title string) string {
+	half := len(title) / 2
+	for half > 0 && !utf8.RuneStart(title[half]) {
+		half--
+	}
+	return title[:half]
+}
+
+// editDistance computes the Levenshtein distance between a and b in runes
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
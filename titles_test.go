@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		// Only the first letter is case insensitive
+		{"new_york_city", "New york city"},
+		{"new_York_City", "New York City"},
+		{"New York City", "New York City"},
+		{"éclair", "Éclair"},
+		{"_New__York  City_", "New York City"},
+		{"  new york ", "New york"},
+		{"eBay", "EBay"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeTitle(tt.raw); got != tt.want {
+			t.Errorf("normalizeTitle(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+	h := newTestHandler(t)
+	for _, target := range []string{"/wiki/new_York_City", "/wiki/New%20York%20City", "/wiki/%C3%A9clair", "/wiki/New__York_City_"} {
+		if rec := get(wikiRoute(h), target+"?action=raw"); rec.Code != 200 {
+			t.Errorf("%s: got %d", target, rec.Code)
+		}
+	}
+}
+
+func TestFindTitle(t *testing.T) {
+	indexPath, contentPath := writeGzipDump(t, "EBay", "The E bay", "eBay", "The auction site", "iPod", "The player", "New York City", "The city")
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	// The title is tried as given first and then with its first letter
+	// uppercased, other letters are never changed
+	tests := []struct {
+		raw, title string
+		found      bool
+		content    string
+	}{
+		{"eBay", "eBay", true, "The auction site"},
+		{"EBay", "EBay", true, "The E bay"},
+		{"iPod", "iPod", true, "The player"},
+		{"IPod", "IPod", false, ""},
+		{"ipod", "Ipod", false, ""},
+		{"new_York_City", "New York City", true, "The city"},
+		{"new york city", "New york city", false, ""},
+		{"nEW_York_City", "NEW York City", false, ""},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/wiki/", wikiRoute(h))
+	wikiRoutes(mux, "", h)
+	for _, tt := range tests {
+		title, _, found := findTitle(h.index(), tt.raw)
+		if title != tt.title || found != tt.found {
+			t.Errorf("findTitle(%q) = %q, %v, want %q, %v", tt.raw, title, found, tt.title, tt.found)
+		}
+		status := http.StatusNotFound
+		if tt.found {
+			status = http.StatusOK
+		}
+		path := strings.ReplaceAll(tt.raw, " ", "_")
+		if rec := get(mux, "/wiki/"+path+"?action=raw"); rec.Code != status || (tt.found && rec.Body.String() != tt.content) {
+			t.Errorf("/wiki/%s: got %d %q, want %d %q", path, rec.Code, rec.Body.String(), status, tt.content)
+		}
+		if rec := get(mux, "/api/exists/"+path); rec.Code != status {
+			t.Errorf("/api/exists/%s: got %d, want %d", path, rec.Code, status)
+		}
+	}
+}
+
+func TestNormalizePrefix(t *testing.T) {
+	for raw, want := range map[string]string{"new_yo": "New yo", "New ": "New ", "new__": "New ", " ": ""} {
+		if got := normalizePrefix(raw); got != want {
+			t.Errorf("normalizePrefix(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestFirstHalf(t *testing.T) {
+	tests := []struct {
+		title, want string
+	}{
+		{"Alan Turing", "Alan "},
+		{"Éclair", "Éc"},
+		{"ÉÉÉ", "É"},
+		{"日本", "日"},
+		{"É", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := firstHalf(tt.title)
+		if got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("firstHalf(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestSuggestTitles(t *testing.T) {
+	index, err := newSortedIndex(map[string]OffsetAndId{
+		"Éclair":      {1, 1},
+		"Éclairs":     {1, 2},
+		"Alan Turing": {1, 3},
+		"Ecology":     {1, 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Éclair", "Éclairs"}
+	if got := suggestTitles(index, "Éclar"); !reflect.DeepEqual(got, want) {
+		t.Errorf("suggestTitles(Éclar) = %q, want %q", got, want)
+	}
+}
+
+func TestContainingTitles(t *testing.T) {
+	index, err := newSortedIndex(map[string]OffsetAndId{
+		"Berlin":         {1, 1},
+		"Berliner Mauer": {1, 2},
+		"East Berlin":    {1, 3},
+		"Ostberlin":      {1, 4},
+		"Paris":          {1, 5},
+		"berlin (band)":  {1, 6},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &wikiData{index: index}
+	tests := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{"berlin", 10, []string{"Berlin", "Berliner Mauer", "berlin (band)", "East Berlin", "Ostberlin"}},
+		{"BERLIN", 4, []string{"Berlin", "Berliner Mauer", "berlin (band)", "East Berlin"}},
+		{"berlin", 2, []string{"Berlin", "Berliner Mauer"}},
+		{"rlin", 10, []string{"Berlin", "Berliner Mauer", "East Berlin", "Ostberlin", "berlin (band)"}},
+		{"london", 10, []string{}},
+	}
+	for _, tt := range tests {
+		if got := containingTitles(index, data.lowercaseTitles(), tt.query, tt.limit); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("containingTitles(%q, %d) = %q, want %q", tt.query, tt.limit, got, tt.want)
+		}
+	}
+}
+
+// generatedIndex is an index of n titles Title 0000000 and so on, it only
+// serves the titles without looking anything up
+type generatedIndex int
+
+func (g generatedIndex) Lookup(string) (OffsetAndId, bool) { return OffsetAndId{}, false }
+func (g generatedIndex) Len() int                          { return int(g) }
+func (g generatedIndex) Title(i int) string                { return fmt.Sprintf("Title %07d", i) }
+
+func TestContainingTitlesScansAllTitles(t *testing.T) {
+	// More titles than the 1<<20 an earlier version looked through
+	const n = 1<<20 + 1000
+	data := &wikiData{index: generatedIndex(n)}
+	lower := data.lowercaseTitles()
+	if len(lower) != n {
+		t.Fatalf("got %d lowercased titles, want %d", len(lower), n)
+	}
+	want := []string{"Title 1049000", "Title 1049001", "Title 1049002"}
+	if got := containingTitles(data.index, lower, "TITLE 10490", 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := containingTitles(data.index, lower, "e 1049575", 3); !reflect.DeepEqual(got, []string{"Title 1049575"}) {
+		t.Errorf("last title: got %q", got)
+	}
+}
+
+func TestFindTitleCache(t *testing.T) {
+	data := newTestHandler(t).data.Load()
+	for _, raw := range []string{"Alan_Turing", "alan Turing", "Nothing here"} {
+		want, wantOffsetAndId, wantOk := findTitle(data.index, raw)
+		for i := 0; i < 2; i++ {
+			title, offsetAndId, ok := data.findTitle(raw)
+			if title != want || offsetAndId != wantOffsetAndId || ok != wantOk {
+				t.Errorf("lookup %d of %q: got %q %v %v, want %q %v %v", i, raw, title, offsetAndId, ok, want, wantOffsetAndId, wantOk)
+			}
+		}
+	}
+	if got := data.resolved.len(); got != 3 {
+		t.Errorf("got %d cached titles, want 3", got)
+	}
+	for _, raw := range []string{strings.Repeat("x", maxTitleBytes+1), "A{{b}}", "x/../y"} {
+		if _, _, ok := data.findTitle(raw); ok {
+			t.Errorf("found invalid title %q", raw)
+		}
+	}
+	if got := data.resolved.len(); got != 3 {
+		t.Errorf("got %d cached titles after invalid ones, want 3", got)
+	}
+}
+
+func TestCompleteTitles(t *testing.T) {
+	index, err := newSortedIndex(testOffsetMap(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		prefix string
+		limit  int
+		want   []string
+	}{
+		{"Title 000099", 5, []string{"Title 0000990", "Title 0000991", "Title 0000992", "Title 0000993", "Title 0000994"}},
+		{"Title 0000999", 5, []string{"Title 0000999"}},
+		{"Title 1", 5, []string{}},
+		{"A", 5, []string{}},
+	}
+	for _, tt := range tests {
+		if got := completeTitles(index, tt.prefix, tt.limit); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("completeTitles(%q, %d) = %q, want %q", tt.prefix, tt.limit, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkCompleteTitles completes prefixes in an index of 300000 titles
+// which should stay well below a millisecond per lookup
+func BenchmarkCompleteTitles(b *testing.B) {
+	const n = 300000
+	for _, kind := range []string{"map", "sorted"} {
+		index, err := newTitleIndex(kind, testOffsetMap(n))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(kind, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				completeTitles(index, fmt.Sprintf("Title %05d", i%(n/100)), defaultCompleteLimit)
+			}
+		})
+	}
+}
+
+// BenchmarkFindTitle compares looking titles up in the index to going
+// through the cache of recent lookups
+func BenchmarkFindTitle(b *testing.B) {
+	data := newTestHandler(b).data.Load()
+	titles := make([]string, 100)
+	for i := range titles {
+		titles[i] = fmt.Sprintf("sample_%03d", i+1)
+	}
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			findTitle(data.index, titles[i%len(titles)])
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data.findTitle(titles[i%len(titles)])
+		}
+	})
+	b.Run("invalid", func(b *testing.B) {
+		long := strings.Repeat("x", 4096)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data.findTitle(long)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"html/template"
+	"strconv"
+	"strings"
+)
+
+// minTOCEntries is the number of headings from which an article gets a table
+// of contents, like on Wikipedia
+const minTOCEntries = 4
+
+// TOCEntry is a heading of an article
+type TOCEntry struct {
+	Text   string
+	Level  int
+	Anchor string
+}
+
+// headingAnchors hands out the anchor ids of an article's headings. Repeated
+// headings get the suffixes _2, _3 and so on, skipping those taken by other
+// headings like "A 2", so every id is unique. An anchor handed out maps to the
+// last suffix tried for it as the start of a heading.
+type headingAnchors map[string]int
+
+func (anchors headingAnchors) next(text string) string {
+	base := strings.ReplaceAll(text, " ", "_")
+	anchor := base
+	for n := anchors[base]; anchors[anchor] > 0; {
+		n++
+		anchors[base] = n
+		anchor = base + "_" + strconv.Itoa(n)
+	}
+	anchors[anchor] = max(anchors[anchor], 1)
+	return anchor
+}
+
+// headingText returns the displayed text of the heading markup
+func headingText(markup string) string {
+	return strings.TrimSpace(stripWikitext(markup))
+}
+
+// buildTOC returns the headings of content in order. The anchors match the
+// ids renderWikitext gives the headings of the same content.
+func buildTOC(content string) []TOCEntry {
+	var entries []TOCEntry
+	anchors := make(headingAnchors)
+	lines := strings.Split(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(lines[i], "*") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "{|") {
+			i = tableEnd(lines, i)
+			continue
+		}
+		m := headingRegexp.FindStringSubmatch(trimmed)
+		if m == nil {
+			continue
+		}
+		text := headingText(m[2])
+		entries = append(entries, TOCEntry{
+			Text:   text,
+			Level:  min(len(m[1]), len(m[3])),
+			Anchor: anchors.next(text),
+		})
+	}
+	return entries
+}
+
+// renderTOC renders entries as nested lists of links to the headings. It
+// returns nothing for articles with fewer than minTOCEntries headings.
+func renderTOC(entries []TOCEntry) string {
+	if len(entries) < minTOCEntries {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("<div id=\"toc\">\n<h2>Contents</h2>\n")
+	// Levels are relative to the first heading so an article starting
+	// with === headings doesn't get empty outer lists
+	var levels []int
+	for _, entry := range entries {
+		for len(levels) > 0 && levels[len(levels)-1] > entry.Level {
+			b.WriteString("</li></ul>\n")
+			levels = levels[:len(levels)-1]
+		}
+		if len(levels) > 0 && levels[len(levels)-1] == entry.Level {
+			b.WriteString("</li>\n<li>")
+		} else {
+			b.WriteString("<ul><li>")
+			levels = append(levels, entry.Level)
+		}
+		b.WriteString("<a href=\"#" + template.HTMLEscapeString(entry.Anchor) + "\">")
+		b.WriteString(template.HTMLEscapeString(entry.Text))
+		b.WriteString("</a>")
+	}
+	for range levels {
+		b.WriteString("</li></ul>\n")
+	}
+	b.WriteString("</div>\n")
+	return b.String()
+}
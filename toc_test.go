@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHeadingAnchors(t *testing.T) {
+	tests := []struct {
+		headings, want []string
+	}{
+		{[]string{"A", "B"}, []string{"A", "B"}},
+		{[]string{"A", "A", "A"}, []string{"A", "A_2", "A_3"}},
+		{[]string{"A", "A", "A 2"}, []string{"A", "A_2", "A_2_2"}},
+		{[]string{"A 2", "A", "A"}, []string{"A_2", "A", "A_3"}},
+		{[]string{"A", "A 2", "A", "A"}, []string{"A", "A_2", "A_3", "A_4"}},
+		{[]string{"A_2", "A 2"}, []string{"A_2", "A_2_2"}},
+	}
+	for _, tt := range tests {
+		anchors := make(headingAnchors)
+		var got []string
+		for _, heading := range tt.headings {
+			got = append(got, anchors.next(heading))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("anchors of %q = %q, want %q", tt.headings, got, tt.want)
+		}
+	}
+}
+
+func TestBuildTOCMatchesRenderedIds(t *testing.T) {
+	content := "== A ==\nx\n== A ==\ny\n== A 2 ==\nz\n"
+	html := renderWikitext(content, defaultLinkBase)
+	for _, entry := range buildTOC(content) {
+		if !strings.Contains(html, `id="`+entry.Anchor+`"`) {
+			t.Errorf("no heading with id %q in %q", entry.Anchor, html)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// verifyReport counts the outcomes of extracting sampled titles
+type verifyReport struct {
+	ok, notFound, failed int
+}
+
+func (r verifyReport) failureRate() float64 {
+	total := r.ok + r.notFound + r.failed
+	if total == 0 {
+		return 0
+	}
+	return float64(r.notFound+r.failed) / float64(total)
+}
+
+// verify extracts samples random titles of the index to check that it
+// matches the content file. A page whose title differs from the one in the
+// index counts as failed.
+func (h *TinyWikiHandler) verify(samples int) verifyReport {
+	var report verifyReport
+	data := h.data.Load()
+	if data.index.Len() == 0 {
+		return report
+	}
+	for i := 0; i < samples; i++ {
+		title := h.randomTitle(data.index)
+		offsetAndId, _ := data.index.Lookup(title)
+		page, err := h.extract(context.Background(), data, title, offsetAndId)
+		switch {
+		case err == errArticleNotFound:
+			logError("Verify:", title, "not found at offset", offsetAndId.Offset)
+			report.notFound++
+		case err != nil:
+			logError("Verify:", title, err)
+			report.failed++
+		case page.Title != title:
+			logError("Verify: expected", title, "but found", page.Title, "with id", offsetAndId.Id)
+			report.failed++
+		default:
+			report.ok++
+		}
+	}
+	return report
+}
+
+func (r verifyReport) write(out io.Writer, name string) {
+	fmt.Fprintf(out, "%s: %d ok, %d not found, %d failed (%.1f%% failures)\n",
+		name, r.ok, r.notFound, r.failed, 100*r.failureRate())
+}
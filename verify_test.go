@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	if report := newTestHandler(t).verify(50); report.ok != 50 {
+		t.Errorf("intact dump: got %+v, want 50 ok", report)
+	}
+
+	// Damage the middle of the stream holding the Sample pages
+	dump, err := os.ReadFile(testContentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams := newTestHandler(t).data.Load().streams
+	start := streams[len(streams)-1] + 100
+	for i := start; i < start+200; i++ {
+		dump[i] ^= 0xff
+	}
+	corrupted := filepath.Join(t.TempDir(), "corrupted.xml.bz2")
+	if err := os.WriteFile(corrupted, dump, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	report := loadTestWiki(t, testIndexPath, corrupted, defaultLinkBase).verify(50)
+	if report.failed+report.notFound == 0 || report.failureRate() <= verifyThreshold {
+		t.Errorf("corrupted dump: got %+v, want failures above %v", report, verifyThreshold)
+	}
+	if report.ok+report.failed+report.notFound != 50 {
+		t.Errorf("corrupted dump: got %+v, want 50 samples", report)
+	}
+}
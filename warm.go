@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// readTitleList reads a file with one title per line. Empty lines and lines
+// starting with # are skipped.
+func readTitleList(listPath string) ([]string, error) {
+	listFile, err := os.Open(listPath)
+	if err != nil {
+		return nil, err
+	}
+	defer listFile.Close()
+	var titles []string
+	scanner := bufio.NewScanner(listFile)
+	for scanner.Scan() {
+		title := strings.TrimSpace(scanner.Text())
+		if title != "" && !strings.HasPrefix(title, "#") {
+			titles = append(titles, title)
+		}
+	}
+	return titles, scanner.Err()
+}
+
+// warm extracts titles into the article cache so their first requests don't
+// have to wait for the dump. As many titles as there are CPUs are extracted
+// at a time. It returns how many of them were found.
+func (h *TinyWikiHandler) warm(titles []string) int {
+	var warmed atomic.Int64
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for title := range work {
+				if _, _, _, err := h.lookupPage(context.Background(), title); err != nil {
+					logInfo("Couldn't warm", title+":", err)
+					continue
+				}
+				warmed.Add(1)
+			}
+		}()
+	}
+	for _, title := range titles {
+		work <- title
+	}
+	close(work)
+	wg.Wait()
+	return int(warmed.Load())
+}
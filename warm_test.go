@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestWarmFillsArticleCache(t *testing.T) {
+	dir := t.TempDir()
+	listPath := filepath.Join(dir, "popular.txt")
+	if err := os.WriteFile(listPath, []byte("# popular articles\nAlan Turing\n\n  NYC  \nMissing article\nSample_042\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	titles, err := readTitleList(listPath)
+	if want := []string{"Alan Turing", "NYC", "Missing article", "Sample_042"}; err != nil || !slices.Equal(titles, want) {
+		t.Fatalf("got %q, %v, want %q", titles, err, want)
+	}
+
+	indexPath, contentPath := filepath.Join(dir, "index.txt.bz2"), filepath.Join(dir, "dump.xml.bz2")
+	copyFile(t, testIndexPath, indexPath)
+	copyFile(t, testContentPath, contentPath)
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	h.data.Store(newWikiData(h.index(), h.data.Load().dump, 10, 0))
+	if warmed := h.warm(titles); warmed != 3 {
+		t.Errorf("warmed %d titles, want 3", warmed)
+	}
+	if err := os.Remove(contentPath); err != nil {
+		t.Fatal(err)
+	}
+	routes := wikiRoute(h)
+	for _, target := range []string{"/wiki/Alan_Turing?action=raw", "/wiki/NYC?action=raw", "/wiki/Sample_042?action=raw"} {
+		hits := metrics.cacheHits.Load()
+		if rec := get(routes, target); rec.Code != http.StatusOK || metrics.cacheHits.Load() == hits {
+			t.Errorf("%s: got %d without a cache hit", target, rec.Code)
+		}
+	}
+	if rec := get(routes, "/wiki/New_York_City?action=raw"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("article not warmed: got %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
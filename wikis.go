@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// wikiConfig describes one of several wikis served side by side
+type wikiConfig struct {
+	lang, indexPath, contentPath string
+}
+
+// wikiConfigs collects the values of the repeatable -wiki flag, each of the
+// form lang=indexpath,contentpath
+type wikiConfigs []wikiConfig
+
+func (c *wikiConfigs) String() string {
+	strs := make([]string, len(*c))
+	for i, wiki := range *c {
+		strs[i] = wiki.lang + "=" + wiki.indexPath + "," + wiki.contentPath
+	}
+	return strings.Join(strs, " ")
+}
+
+func (c *wikiConfigs) Set(value string) error {
+	lang, paths, ok := strings.Cut(value, "=")
+	if !ok || lang == "" || strings.Contains(lang, "/") {
+		return fmt.Errorf("expected lang=indexpath,contentpath but got %q", value)
+	}
+	indexPath, contentPath, ok := strings.Cut(paths, ",")
+	if !ok || indexPath == "" || contentPath == "" {
+		return fmt.Errorf("expected lang=indexpath,contentpath but got %q", value)
+	}
+	for _, wiki := range *c {
+		if wiki.lang == lang {
+			return fmt.Errorf("wiki %q given twice", lang)
+		}
+	}
+	*c = append(*c, wikiConfig{lang, indexPath, contentPath})
+	return nil
+}
+
+// loadWiki reads the index of a wiki and creates its handler
+func loadWiki(indexPath, contentPath, cachePath, linkBase string, namespaces namespaceSet) (*TinyWikiHandler, error) {
+	data, err := loadWikiData(indexPath, contentPath, cachePath, namespaces)
+	if err != nil {
+		return nil, err
+	}
+	h := newTinyWikiHandler(data, linkBase)
+	h.indexPath, h.cachePath, h.namespaces = indexPath, cachePath, namespaces
+	h.extractTimeout, h.maxBytes = extractTimeout, maxBytes
+	if h.renderer, err = newRenderer(rendererName, linkBase); err != nil {
+		return nil, err
+	}
+	switch matchBy {
+	case "id":
+	case "title":
+		h.matchByTitle = true
+	default:
+		return nil, fmt.Errorf("unknown -matchby %q, expected id or title", matchBy)
+	}
+	return h, nil
+}
+
+// loadWikiData reads the index of the dump at contentPath, either from
+// indexPath or with -singlestream from the dump itself, drops the titles
+// -validateoffsets and -blocklist rule out and derives everything needed to
+// serve the dump from the rest.
+func loadWikiData(indexPath, contentPath, cachePath string, namespaces namespaceSet) (*wikiData, error) {
+	if _, err := decompressorFor(contentPath); err != nil {
+		return nil, err
+	}
+	dump, err := newDumpSource(contentPath, singleStream)
+	if err != nil {
+		return nil, err
+	}
+	var offsetMap map[string]OffsetAndId
+	if singleStream {
+		offsetMap, err = singleStreamOffsets(contentPath, cachePath, namespaces)
+	} else {
+		offsetMap, err = loadOffsetMap(indexPath, cachePath, namespaces, dump.fileBases())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if validateOffsets {
+		if err := dropInvalidOffsets(offsetMap, dump); err != nil {
+			return nil, err
+		}
+	}
+	if blocklistPath != "" {
+		if err := dropBlocked(offsetMap, blocklistPath); err != nil {
+			return nil, err
+		}
+	}
+	index, err := newTitleIndex(indexKind, offsetMap)
+	if err != nil {
+		return nil, err
+	}
+	return newWikiData(index, dump, articleCacheSize, chunkCacheBytes), nil
+}
+
+// reload reads the index again, e.g. after a new dump was put in place, and
+// swaps it in. Requests already being served finish with the old index. Only
+// one reload runs at a time so an older index never replaces a newer one.
+func (h *TinyWikiHandler) reload() error {
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	data, err := loadWikiData(h.indexPath, h.contentFilePath, h.cachePath, h.namespaces)
+	if err != nil {
+		return err
+	}
+	h.data.Store(data)
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	indexPath, contentPath := filepath.Join(dir, "index.txt.bz2"), filepath.Join(dir, "dump.xml.bz2")
+	copyFile(t, "testdata/dewiki-index.txt.bz2", indexPath)
+	copyFile(t, "testdata/dewiki.xml.bz2", contentPath)
+	h := loadTestWiki(t, indexPath, contentPath, defaultLinkBase)
+	if rec := get(wikiRoute(h), "/wiki/Alan_Turing?action=raw"); !strings.Contains(rec.Body.String(), "britischer") {
+		t.Fatalf("before reload: got %d %q", rec.Code, rec.Body.String())
+	}
+	old := h.data.Load()
+
+	copyFile(t, testIndexPath, indexPath)
+	copyFile(t, testContentPath, contentPath)
+	// Reloads running at once while requests are served, run with -race
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := h.reload(); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			get(wikiRoute(h), "/wiki/Alan_Turing?action=raw")
+		}()
+	}
+	wg.Wait()
+
+	if h.data.Load() == old {
+		t.Error("reload kept the old index")
+	}
+	if rec := get(wikiRoute(h), "/wiki/Alan_Turing?action=raw"); !strings.Contains(rec.Body.String(), "was an English") {
+		t.Errorf("after reload: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := get(wikiRoute(h), "/wiki/Berlin"); rec.Code != 404 {
+		t.Errorf("after reload: got %d for a title only in the old index, want 404", rec.Code)
+	}
+
+	os.Remove(indexPath)
+	if err := h.reload(); err == nil {
+		t.Error("reload without index succeeded")
+	}
+	if rec := get(wikiRoute(h), "/wiki/Éclair?action=raw"); rec.Code != 200 {
+		t.Errorf("after a failed reload: got %d, want the last index kept", rec.Code)
+	}
+}
+
+// TestReloadFiltersLikeLoad checks that a reload drops the titles of the
+// blocklist the same way the first load does
+func TestReloadFiltersLikeLoad(t *testing.T) {
+	blocklist := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(blocklist, []byte("Mercury\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	blocklistPath = blocklist
+	defer func() { blocklistPath = "" }()
+	h := newTestHandler(t)
+	if rec := get(wikiRoute(h), "/wiki/Mercury"); rec.Code != http.StatusNotFound {
+		t.Fatalf("blocked title after load: got %d", rec.Code)
+	}
+	if err := os.WriteFile(blocklist, []byte("Mercury\nÉclair\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.reload(); err != nil {
+		t.Fatal(err)
+	}
+	for target, status := range map[string]int{"/wiki/Mercury": 404, "/wiki/%C3%89clair": 404, "/wiki/Alan_Turing": 200} {
+		if rec := get(wikiRoute(h), target); rec.Code != status {
+			t.Errorf("%s after reload: got %d, want %d", target, rec.Code, status)
+		}
+	}
+}